@@ -1,190 +1,7113 @@
-package main
-
-import (
-    "flag"
-    "fmt"
-    "net"
-    "strconv"
-    "strings"
-    "sync"
-    "time"
-)
-
-func checkHostAlive(host string, port int, timeout time.Duration) bool {
-    conn, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%d", host, port), timeout)
-    if err == nil {
-        defer conn.Close()
-        return true
-    }
-    return false
-}
-
-func scanPort(host string, port int, timeout time.Duration, results chan int, wg *sync.WaitGroup) {
-    defer wg.Done()
-    if checkHostAlive(host, port, timeout) {
-        results <- port
-    }
-}
-
-func scanHost(host string, ports []int, timeout time.Duration, verbose bool) []int {
-    openPorts := []int{}
-    wg := sync.WaitGroup{}
-    results := make(chan int)
-    for _, port := range ports {
-        wg.Add(1)
-        go scanPort(host, port, timeout, results, &wg)
-    }
-    go func() {
-        wg.Wait()
-        close(results)
-    }()
-    for port := range results {
-        openPorts = append(openPorts, port)
-    }
-    if verbose {
-        if len(openPorts) > 0 {
-            fmt.Printf("%s is alive\n", host)
-            fmt.Printf("%s has open ports: %v\n", host, openPorts)
-        } else {
-            fmt.Printf("%s is not alive\n", host)
-        }
-    }
-    return openPorts
-}
-
-func parsePorts(portRange string) []int {
-    ports := []int{}
-    if portRange == "" {
-        ports = []int{21,22,23,25,53,80,81,88,89,110,113,119,123,135,139,143,161,179,199,389,427,443,445,465,513,514,
-            515,543,544,548,554,587,631,646,873,902,990,993,995,1080,1433,1521,1701,1720,1723,1755,1900,2000,2049,
-            2121,2181,2375,2376,3128,3306,3389,3500,3541,3689,4000,4040,4063,4333,4369,4443,4488,4500,4567,4899,
-            5000,5001,5004,5006,5007,5008,5009,5060,5104,5222,5223,5269,5351,5353,5432,5555,5601,5632,5800,5801,
-            5900,5901,5938,5984,5999,6000,6001,6379,6443,6588,6665,6666,6667,6668,6669,7001,7002,7077,7443,7574,
-            8000,8001,8008,8010,8080,8081,8082,8086,8088,8090,8091,8181,8443,8484,8600,8649,8686,8787,8888,9000,
-            9001,9002,9003,9009,9042,9050,9071,9080,9090,9091,9200,9300,9418,9443,9600,9800,9871,9999,10000,11211,
-            12345,15672,16010,16080,16384,27017,27018,50050}
-    } else {
-        for _, item := range strings.Split(portRange, ",") {
-            if strings.Contains(item, "-") {
-                rangeParts := strings.Split(item, "-")
-                startPort, _ := strconv.Atoi(rangeParts[0])
-                endPort, _ := strconv.Atoi(rangeParts[1])
-                for i := startPort; i <= endPort; i++ {
-                    ports = append(ports, i)
-                }
-            } else {
-                port, _ := strconv.Atoi(item)
-                ports = append(ports, port)
-            }
-        }
-    }
-    return ports
-}
-
-func scanNetwork(network string, portRange string, timeout time.Duration, maxWorkers int, verbose bool) []map[string][]int {
-    var results []map[string][]int
-    hosts, err := hostsInNetwork(network)
-    if err != nil {
-        fmt.Printf("Error: %v\n", err)
-        return results
-    }
-    ch := make(chan string, maxWorkers)
-    workerResultsCh := make(chan map[string][]int, len(hosts))
-    ports := parsePorts(portRange)
-    results = make([]map[string][]int, len(hosts))
-    for i := 0; i < maxWorkers; i++ {
-        go func() {
-            for host := range ch {
-                openPorts := scanHost(host, ports, timeout, verbose)
-                if len(openPorts) > 0 {
-                    result := make(map[string][]int)
-                    result[host] = openPorts
-                    workerResultsCh <- result
-                } else {
-                    workerResultsCh <- nil
-                }
-            }
-        }()
-    }
-    for _, host := range hosts {
-        ch <- host
-    }
-    close(ch)
-    for i := 0; i < len(hosts); i++ {
-        result := <-workerResultsCh
-        if result != nil {
-            results[i] = result
-        }
-    }
-    return results
-}
-
-func hostsInNetwork(network string) ([]string, error) {
-    ips := []string{}
-    ip, ipNet, err := net.ParseCIDR(network)
-    if err != nil {
-        return ips, err
-    }
-    for ip := ip.Mask(ipNet.Mask); ipNet.Contains(ip); inc(ip) {
-        ips = append(ips, ip.String())
-    }
-    lenIps := len(ips)
-    switch {
-    case lenIps == 0:
-        return ips, fmt.Errorf("no IP addresses found in network")
-    default:
-        return ips, nil
-    }
-}
-
-func inc(ip net.IP) {
-    for j := len(ip) - 1; j >= 0; j-- {
-        ip[j]++
-        if ip[j] > 0 {
-            break
-        }
-    }
-}
-
-var (
-    network   string
-    portRange string
-    timeout   int
-    maxWorkers int
-    verbose   bool
-)
-
-func init() {
-    flag.StringVar(&network, "n", "", "Network to scan (e.g. \"192.168.0.1\" or \"192.168.0.0/24\")")
-    flag.StringVar(&portRange, "p", "", "Ports to scan (e.g. \"80\" or \"1-65535\")")
-    flag.IntVar(&timeout, "t", 500, "TCP connection timeout in milliseconds")
-    flag.IntVar(&maxWorkers, "w", 100, "Maximum number of worker threads for the scan")
-    flag.BoolVar(&verbose, "v", false, "Verbose output")
-}
-
-func main() {
-    flag.Parse()
-
-    if network == "" {
-        fmt.Println("Please specify a network to scan")
-        return
-    }
-    timeoutDuration := time.Duration(timeout) * time.Millisecond
-
-    start := time.Now()
-    fmt.Printf("[*] Scanning network %s (%s)...\n", network, portRange)
-    results := scanNetwork(network, portRange, timeoutDuration, maxWorkers, verbose)
-    elapsed := time.Since(start)
-
-    if len(results) > 0 {
-        fmt.Printf("[+] Found open ports on %d host(s):\n", len(results))
-        for _, result := range results {
-            for host, openPorts := range result {
-                fmt.Printf("    %s: %v\n", host, openPorts)
-            }
-        }
-    } else {
-        fmt.Println("[-] No open ports found on any host.")
-    }
-    fmt.Printf("[+] Scan completed in %v.\n", elapsed)
-}
+package main
+
+import (
+    "bufio"
+    "context"
+    "crypto/sha256"
+    "crypto/tls"
+    "encoding/hex"
+    "encoding/base64"
+    "encoding/binary"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "html"
+    "io"
+    "math"
+    "math/rand"
+    "net"
+    "net/http"
+    "net/url"
+    "os"
+    "os/exec"
+    "os/signal"
+    "path/filepath"
+    "runtime"
+    "runtime/debug"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "syscall"
+    "time"
+    "unicode"
+    "unicode/utf8"
+)
+
+type Config struct {
+    // Targets and Ports are what ScanNetwork scans: networks, addresses or
+    // names, and a port list in -p syntax, "" for each protocol's default
+    // ports.
+    Targets     []string
+    Ports       string
+    Protocols   []string
+    // Timeout bounds establishing a connection (or waiting for a raw/UDP
+    // reply); ReadTimeout bounds reads after connecting and defaults to
+    // Timeout when zero.
+    Timeout     time.Duration
+    ReadTimeout time.Duration
+    HostTimeout time.Duration
+    MaxWorkers  int
+    // MaxConnsPerHost limits the probes run against a single host at the same
+    // time; 0 means one per port and protocol, all at once.
+    MaxConnsPerHost int
+    // Retries is how many more times a connect probe is tried after a
+    // timeout or transient local error; refused ports are never retried.
+    Retries int
+    Verbose     bool
+    // Sample below 1 scans that fraction of the hosts, otherwise that many
+    // hosts; 0 scans everything. Seed drives the random choice.
+    Sample float64
+    Seed   int64
+    // Banners and TLSInspect enable the extra connections made to open TCP
+    // ports after the scan probe succeeds.
+    Banners    bool
+    TLSInspect bool
+    // BannerBytes caps how much of a banner is read; 0 means 1024.
+    BannerBytes int
+    // Favicon fetches /favicon.ico from open TCP ports that speak HTTP(S).
+    Favicon bool
+    // HTTP sends an HTTPMethod request for HTTPPath to open TCP ports and
+    // records the status, Server header and page title of the answer.
+    HTTP       bool
+    HTTPMethod string
+    HTTPPath   string
+    // TLSEnum handshakes once per TLS version and cipher suite to list what
+    // each open TLS port accepts.
+    TLSEnum bool
+    // Names looks up a hostname for each IP with findings: reverse DNS
+    // first, then mDNS and NetBIOS for LAN hosts without a PTR record.
+    Names bool
+    // GeoIP annotates public hosts from a MaxMind City/Country/ASN database.
+    GeoIP *mmdbReader
+    // ARP fills in MAC and vendor for hosts on a directly connected segment.
+    ARP bool
+    // IdentifyProcess names the local process listening on each open port
+    // of a loopback target, from /proc (Linux).
+    IdentifyProcess bool
+    // SNINames are presented one handshake at a time to every open TCP port
+    // to see which certificate each virtual host gets.
+    SNINames []string
+    // Proxy, when set, carries every TCP connection; UDP is always direct.
+    Proxy *socksProxy
+    // Resolver, when set, is used for every hostname lookup instead of the
+    // system resolver.
+    Resolver *net.Resolver
+    // DNSTimeout bounds each hostname lookup, separately from Timeout; a
+    // name that does not resolve in time is reported and skipped. Zero
+    // means five seconds.
+    DNSTimeout time.Duration
+    // Bandwidth, when set, throttles the data read and written by banner, TLS
+    // and HTTP connections.
+    Bandwidth *bandwidthLimiter
+    // Reasons records why each port got its state, and makes connect probes
+    // watch briefly for a reset straight after the handshake.
+    Reasons bool
+    // MaxRetriesTotal, when positive, caps the retries of the whole scan,
+    // so Retries cannot stretch a scan of a lossy network without bound.
+    MaxRetriesTotal int
+    // AbortOpen closes accepted connect probes with a RST (SO_LINGER 0)
+    // instead of a graceful FIN. It has no effect through a proxy.
+    AbortOpen bool
+    // ShowSource records the local address of each accepted connect probe.
+    ShowSource bool
+    // Adaptive shares MaxWorkers*32 in-flight probes between hosts, giving
+    // each a window that grows while it answers quickly and shrinks when
+    // it times out. Otherwise every host probes all its ports at once.
+    Adaptive bool
+    // OnHostComplete, when set, is called with every host's result as soon
+    // as that host is finished, whether or not anything was found. It runs
+    // on the scan's worker goroutines, so it is called concurrently and must
+    // be safe for that; the worker waits for it, so it should return quickly.
+    // ARP and GeoIP details are added after the whole scan and are absent.
+    OnHostComplete func(HostResult)
+    // FindingsLog, when set, receives every host with findings as soon as
+    // it completes, before ARP and GeoIP details are added.
+    FindingsLog *findingsLog
+    // Events, when set, receives a progress event per finished host.
+    Events *eventStream
+    // Checkpoint, when set, skips probes finished by an earlier run and
+    // records the ones finished by this one.
+    Checkpoint *checkpoint
+    // Cache, when set, answers probes from results recorded within its TTL
+    // instead of sending them, and records the results of new ones.
+    Cache *resultCache
+    // TargetPorts maps a target to the port list it is scanned with instead
+    // of the global one, from the optional second column of -iL.
+    TargetPorts map[string]string
+    // MaxGoroutines, when positive, caps the goroutines the scan itself
+    // starts: probes then run on a fixed pool instead of one goroutine each.
+    MaxGoroutines int
+    // Sequential probes each host's ports one at a time, in order, for
+    // devices that misbehave under parallel connections or use port
+    // knocking. Hosts are still scanned MaxWorkers at a time.
+    Sequential bool
+    // Slowest is how many of the slowest hosts Stats keeps; 0 keeps none.
+    Slowest int
+    // Flat probes the (host, port) pairs of all hosts from one pool of
+    // MaxWorkers probes rather than scanning MaxWorkers hosts at a time.
+    Flat bool
+    // PreserveOrder probes ports in the order given, without sorting or
+    // dropping duplicates.
+    PreserveOrder bool
+    // FromHost starts the enumeration of a CIDR with host bits set at that
+    // address instead of the network address.
+    FromHost bool
+    // QuietSpecialUse silences the warnings about loopback, link-local and
+    // similar targets; AllowMulticast scans multicast targets instead of
+    // skipping them.
+    QuietSpecialUse bool
+    AllowMulticast  bool
+    // Jitter is the upper bound of a random delay added before each probe.
+    Jitter time.Duration
+    // ScanType selects how TCP ports are probed: "connect" (the default) or
+    // one of the raw socket scans in rawScanFlags, which need privileges.
+    ScanType string
+    // Decoys are spoofed source addresses each SYN scan probe is also sent
+    // from, in order; a nil entry is where the real probe goes. Linux only.
+    Decoys []net.IP
+
+    // progress, when set, is called from the collecting goroutine after each
+    // host finishes; result is nil for hosts with nothing to report.
+    progress func(done, total int, result *HostResult)
+    // pause, when set, holds back new probes while the user has paused.
+    pause *pauseGate
+    // probeBudget and window implement Adaptive scheduling: the shared
+    // in-flight probe budget and the current host's window.
+    probeBudget chan struct{}
+    window      *hostWindow
+    // pool runs the probes when MaxGoroutines is set.
+    pool *probePool
+    // abort ends the scan early, as when the targets turn out unroutable.
+    abort *scanAbort
+    // hostnames caches the -names lookups of the current scan.
+    hostnames *hostnameCache
+    // retries is what is left of MaxRetriesTotal.
+    retries *retryBudget
+    // rtt watches answer times for a Timeout that is too tight.
+    rtt *timeoutCheck
+    // probeErrors counts the probes that failed on our side.
+    probeErrors *probeErrors
+
+    rng        *lockedRand
+    raw        *rawScanner
+    httpClient *http.Client
+    resolved   map[string]string
+    // rawSkipped holds the targets the raw scanner cannot probe, which
+    // get a connect scan instead.
+    rawSkipped map[string]bool
+}
+
+// enrichments names what scanPort collects for an open TCP port beyond its
+// state, so cached results can be matched against what a scan asks for.
+func (cfg Config) enrichments() []string {
+    var names []string
+    if cfg.Banners {
+        names = append(names, "banner")
+    }
+    if cfg.TLSInspect {
+        names = append(names, "tls")
+    }
+    if cfg.Favicon {
+        names = append(names, "favicon")
+    }
+    if cfg.HTTP {
+        names = append(names, "http "+cfg.HTTPMethod+" "+cfg.HTTPPath)
+    }
+    if cfg.TLSEnum {
+        names = append(names, "tls-enum")
+    }
+    for _, name := range cfg.SNINames {
+        names = append(names, "sni "+name)
+    }
+    return names
+}
+
+// readTimeout is how long banner, TLS and HTTP probes wait for the service
+// to answer once connected.
+func (cfg Config) readTimeout() time.Duration {
+    if cfg.ReadTimeout > 0 {
+        return cfg.ReadTimeout
+    }
+    return cfg.Timeout
+}
+
+// rawFor returns the raw scanner to probe host with, or nil when it gets a
+// connect scan.
+func (cfg Config) rawFor(host string) *rawScanner {
+    if cfg.rawSkipped[host] {
+        return nil
+    }
+    return cfg.raw
+}
+
+// address returns the IP a hostname target was resolved to before the scan,
+// or host unchanged.
+func (cfg Config) address(host string) string {
+    if ip, ok := cfg.resolved[host]; ok {
+        return ip
+    }
+    return host
+}
+
+// lockedRand is a seeded source shared by the probe goroutines.
+type lockedRand struct {
+    mu  sync.Mutex
+    rng *rand.Rand
+}
+
+func newLockedRand(seed int64) *lockedRand {
+    return &lockedRand{rng: rand.New(rand.NewSource(seed))}
+}
+
+func (r *lockedRand) Int63n(n int64) int64 {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    return r.rng.Int63n(n)
+}
+
+func (r *lockedRand) Perm(n int) []int {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    return r.rng.Perm(n)
+}
+
+type PortResult struct {
+    Port     int    `json:"port"`
+    Protocol string `json:"protocol"`
+    State    string   `json:"state"`
+    // Reason is the evidence for State (e.g. "syn-ack", "conn-refused"),
+    // recorded with -reason.
+    Reason   string   `json:"reason,omitempty"`
+    // Source is the local address and port an accepted connect probe came
+    // from, recorded with -show-source.
+    Source   string   `json:"source,omitempty"`
+    // Service is the conventional name of the port from the services
+    // database, not something the probe confirmed.
+    Service  string   `json:"service,omitempty"`
+    Probe    string   `json:"probe,omitempty"`
+    // ScanType is how a TCP port was probed: "connect" or a raw scan type
+    // such as "syn".
+    ScanType string   `json:"scan_type,omitempty"`
+    Banner   string   `json:"banner,omitempty"`
+    // BannerTruncated is set when the service sent more than -banner-bytes.
+    BannerTruncated bool `json:"banner_truncated,omitempty"`
+    TLS      *TLSInfo `json:"tls,omitempty"`
+    // Process is the local process listening on the port, "name (pid N)",
+    // for loopback targets scanned with -identify-process.
+    Process  string   `json:"process,omitempty"`
+    // FaviconHash is the Shodan-style mmh3 hash of /favicon.ico.
+    FaviconHash *int32 `json:"favicon_hash,omitempty"`
+    HTTP        *HTTPInfo `json:"http,omitempty"`
+    TLSVersions []string `json:"tls_versions,omitempty"`
+    TLSCiphers  []string `json:"tls_ciphers,omitempty"`
+    SNICerts    map[string]*TLSInfo `json:"sni_certs,omitempty"`
+}
+
+type TLSInfo struct {
+    Subject     string    `json:"subject"`
+    Issuer      string    `json:"issuer"`
+    NotAfter    time.Time `json:"not_after"`
+    Fingerprint string    `json:"fingerprint"`
+}
+
+// HTTPInfo is the answer of an open port to the -http request. Redirects
+// are recorded, not followed.
+type HTTPInfo struct {
+    Scheme   string `json:"scheme"`
+    Status   int    `json:"status"`
+    Server   string `json:"server,omitempty"`
+    Location string `json:"location,omitempty"`
+    Title    string `json:"title,omitempty"`
+}
+
+// FingerprintCluster is a set of hosts answering with the same banner or
+// certificate on one port, which usually means a load balancer or clones.
+type FingerprintCluster struct {
+    Port        string   `json:"port"`
+    Kind        string   `json:"kind"`
+    Fingerprint string   `json:"fingerprint"`
+    Hosts       []string `json:"hosts"`
+}
+
+// Results is a set of host results with helpers for common filtering.
+// Closed and filtered ports are not kept in HostResult.Ports, so ByState
+// only finds the states that are reported (open, open|filtered, ...).
+type Results []HostResult
+
+// HostPort is a reported port together with the host it was found on.
+type HostPort struct {
+    Host string `json:"host"`
+    PortResult
+}
+
+// OpenPorts returns the open ports of every host, in host order.
+func (r Results) OpenPorts() []HostPort {
+    return r.ByState("open")
+}
+
+// ByState returns the ports of every host that are in state.
+func (r Results) ByState(state string) []HostPort {
+    ports := []HostPort{}
+    for _, host := range r {
+        for _, port := range host.Ports {
+            if port.State == state {
+                ports = append(ports, HostPort{Host: host.Host, PortResult: port})
+            }
+        }
+    }
+    return ports
+}
+
+// WithOpenPort returns the hosts that have any of ports open, on any
+// protocol.
+func (r Results) WithOpenPort(ports []int) Results {
+    wanted := map[int]bool{}
+    for _, port := range ports {
+        wanted[port] = true
+    }
+    hosts := Results{}
+    for _, host := range r {
+        for _, port := range host.Ports {
+            if port.State == "open" && wanted[port.Port] {
+                hosts = append(hosts, host)
+                break
+            }
+        }
+    }
+    return hosts
+}
+
+// HostsUp returns the hosts whose State is "up". ScanNetwork only returns
+// hosts with reported ports, so on its results this leaves out hosts that
+// are up with every port closed or filtered; Stats.HostsUp counts those.
+func (r Results) HostsUp() []HostResult {
+    hosts := []HostResult{}
+    for _, host := range r {
+        if host.State == "up" {
+            hosts = append(hosts, host)
+        }
+    }
+    return hosts
+}
+
+// Stats summarizes a scan for reporting.
+type Stats struct {
+    // HostsScanned leaves out hosts skipped because the scan was aborted.
+    HostsScanned int           `json:"hosts_scanned"`
+    // HostsUp counts hosts whose State is "up", open ports or not.
+    HostsUp      int           `json:"hosts_up"`
+    OpenPorts    int           `json:"open_ports"`
+    // ProbesSent counts port/protocol pairs probed, not retries.
+    ProbesSent   int           `json:"probes_sent"`
+    Elapsed      time.Duration `json:"elapsed_ns"`
+    ProbesPerSec float64       `json:"probes_per_sec"`
+    // Slowest lists the hosts that took longest, slowest first, when
+    // -slowest asks for them.
+    Slowest      []HostTime    `json:"slowest,omitempty"`
+    // ProbeErrors counts the probes that failed on the scanning side or the
+    // network's (see infrastructureError) rather than getting an answer.
+    ProbeErrors  map[string]int `json:"probe_errors,omitempty"`
+}
+
+// HostTime is how long a host took to scan.
+type HostTime struct {
+    Host    string        `json:"host"`
+    State   string        `json:"state,omitempty"`
+    Elapsed time.Duration `json:"elapsed_ns"`
+}
+
+func (s *Stats) add(result HostResult) {
+    if result.Probed == 0 {
+        return
+    }
+    s.HostsScanned++
+    if result.State == "up" {
+        s.HostsUp++
+    }
+    s.OpenPorts += result.Open
+    s.ProbesSent += result.Probed - result.NotScanned
+}
+
+// noteTime keeps result among the n slowest hosts if it is one of them.
+func (s *Stats) noteTime(result HostResult, n int) {
+    if result.Probed == 0 {
+        return
+    }
+    i := sort.Search(len(s.Slowest), func(i int) bool { return s.Slowest[i].Elapsed < result.Elapsed })
+    if i >= n {
+        return
+    }
+    s.Slowest = append(s.Slowest, HostTime{})
+    copy(s.Slowest[i+1:], s.Slowest[i:])
+    s.Slowest[i] = HostTime{Host: result.Host, State: result.State, Elapsed: result.Elapsed}
+    if len(s.Slowest) > n {
+        s.Slowest = s.Slowest[:n]
+    }
+}
+
+// probeErrorSummary lists the ProbeErrors as "name xN", most frequent first.
+func (s Stats) probeErrorSummary() string {
+    names := make([]string, 0, len(s.ProbeErrors))
+    for name := range s.ProbeErrors {
+        names = append(names, name)
+    }
+    sort.Slice(names, func(i, j int) bool {
+        if s.ProbeErrors[names[i]] != s.ProbeErrors[names[j]] {
+            return s.ProbeErrors[names[i]] > s.ProbeErrors[names[j]]
+        }
+        return names[i] < names[j]
+    })
+    parts := []string{}
+    for _, name := range names {
+        parts = append(parts, fmt.Sprintf("%s x%d", name, s.ProbeErrors[name]))
+    }
+    return strings.Join(parts, ", ")
+}
+
+func (s Stats) finish(start time.Time) Stats {
+    s.Elapsed = time.Since(start)
+    if seconds := s.Elapsed.Seconds(); seconds > 0 {
+        s.ProbesPerSec = float64(s.ProbesSent) / seconds
+    }
+    return s
+}
+
+type HostResult struct {
+    Host     string        `json:"host"`
+    Hostname string        `json:"hostname,omitempty"`
+    MAC      string        `json:"mac,omitempty"`
+    Vendor   string        `json:"vendor,omitempty"`
+    Geo      *GeoInfo      `json:"geo,omitempty"`
+    // State is "up" when any probe got an answer, open or not, "down" when
+    // none did and "unknown" when nothing finished; see hostState.
+    State    string        `json:"state,omitempty"`
+    Ports    []PortResult  `json:"ports"`
+    // Open is how many of the Probed port/protocol pairs were open.
+    Open     int           `json:"open"`
+    Probed   int           `json:"probed"`
+    // Filtered counts the probes that were dropped or rejected by a
+    // firewall; they are not listed in Ports.
+    Filtered int           `json:"filtered,omitempty"`
+    Elapsed  time.Duration `json:"elapsed_ns"`
+    // TimedOut is set when the host exceeded its -host-timeout budget;
+    // NotScanned counts the probes abandoned as a result.
+    TimedOut   bool `json:"timed_out,omitempty"`
+    NotScanned int  `json:"not_scanned,omitempty"`
+    // LikelyHoneypot is set when nearly every probed port was open, which
+    // real hosts don't do but honeypots and some middleboxes do.
+    LikelyHoneypot bool `json:"likely_honeypot,omitempty"`
+}
+
+type GeoInfo struct {
+    Country string `json:"country,omitempty"`
+    City    string `json:"city,omitempty"`
+    ASN     uint64 `json:"asn,omitempty"`
+    Org     string `json:"org,omitempty"`
+}
+
+func (g GeoInfo) String() string {
+    parts := []string{}
+    for _, part := range []string{g.Country, g.City} {
+        if part != "" {
+            parts = append(parts, part)
+        }
+    }
+    if g.ASN != 0 {
+        parts = append(parts, fmt.Sprintf("AS%d", g.ASN))
+    }
+    if g.Org != "" {
+        parts = append(parts, g.Org)
+    }
+    return strings.Join(parts, " ")
+}
+
+// scanMetadata makes a saved report self-describing.
+type scanMetadata struct {
+    Version string    `json:"version"`
+    // Tag is the -tag label of the scan, for archiving and correlation.
+    Tag     string    `json:"tag,omitempty"`
+    Args    []string  `json:"args"`
+    Targets []string  `json:"targets"`
+    // Ports is the -p spec, "default" for the built-in list, and PortCount
+    // the number of ports it expands to.
+    Ports   string    `json:"ports"`
+    PortCount int     `json:"port_count"`
+    Start   time.Time `json:"start"`
+    End     time.Time `json:"end"`
+}
+
+type scanReport struct {
+    Metadata      scanMetadata   `json:"metadata"`
+    Hosts         []HostResult   `json:"hosts"`
+    PortFrequency map[string]int `json:"port_frequency"`
+    // ByPort lists, for each open "port/protocol", the hosts that had it
+    // open, when -by-port asks for it.
+    ByPort        map[string][]string `json:"by_port,omitempty"`
+    Clusters      []FingerprintCluster `json:"clusters,omitempty"`
+    Hash          string         `json:"hash,omitempty"`
+    Stats         *Stats         `json:"stats,omitempty"`
+}
+
+func (h HTTPInfo) String() string {
+    s := fmt.Sprintf("%s: %d", h.Scheme, h.Status)
+    if h.Title != "" {
+        s += fmt.Sprintf(" %q", h.Title)
+    }
+    if h.Location != "" {
+        s += " -> " + h.Location
+    }
+    if h.Server != "" {
+        s += " (" + h.Server + ")"
+    }
+    return s
+}
+
+func (p HostPort) String() string {
+    return p.Host + " " + p.PortResult.String()
+}
+
+func (r PortResult) String() string {
+    if r.Service != "" {
+        return fmt.Sprintf("%d/%s %s %s", r.Port, r.Protocol, r.State, r.Service)
+    }
+    return fmt.Sprintf("%d/%s %s", r.Port, r.Protocol, r.State)
+}
+
+// Detail is String with how the port was probed, for verbose output:
+// "80/tcp open http (scan:syn)", "53/udp open domain (probe:dns)".
+func (r PortResult) Detail() string {
+    labels := []string{}
+    if r.ScanType != "" {
+        labels = append(labels, "scan:"+r.ScanType)
+    }
+    if r.Probe != "" {
+        labels = append(labels, "probe:"+r.Probe)
+    }
+    if len(labels) == 0 {
+        return r.String()
+    }
+    return fmt.Sprintf("%s (%s)", r, strings.Join(labels, ", "))
+}
+
+// embeddedServices is used when the system services file is missing, as in
+// minimal containers.
+var embeddedServices = map[string]string{
+    "21/tcp": "ftp", "22/tcp": "ssh", "23/tcp": "telnet", "25/tcp": "smtp", "53/tcp": "domain", "53/udp": "domain",
+    "67/udp": "bootps", "68/udp": "bootpc", "69/udp": "tftp", "80/tcp": "http", "88/tcp": "kerberos", "88/udp": "kerberos",
+    "110/tcp": "pop3", "111/tcp": "sunrpc", "111/udp": "sunrpc", "119/tcp": "nntp", "123/udp": "ntp",
+    "135/tcp": "epmap", "137/udp": "netbios-ns", "138/udp": "netbios-dgm", "139/tcp": "netbios-ssn",
+    "143/tcp": "imap", "161/udp": "snmp", "162/udp": "snmp-trap", "179/tcp": "bgp", "389/tcp": "ldap",
+    "443/tcp": "https", "443/udp": "https", "445/tcp": "microsoft-ds", "465/tcp": "submissions", "500/udp": "isakmp",
+    "514/udp": "syslog", "515/tcp": "printer", "548/tcp": "afpovertcp", "554/tcp": "rtsp", "587/tcp": "submission",
+    "631/tcp": "ipp", "636/tcp": "ldaps", "873/tcp": "rsync", "993/tcp": "imaps", "995/tcp": "pop3s",
+    "1080/tcp": "socks", "1194/udp": "openvpn", "1433/tcp": "ms-sql-s", "1521/tcp": "ncube-lm", "1723/tcp": "pptp",
+    "1883/tcp": "mqtt", "1900/udp": "ssdp", "2049/tcp": "nfs", "2181/tcp": "zookeeper", "2375/tcp": "docker",
+    "2376/tcp": "docker-s", "3128/tcp": "squid-http", "3306/tcp": "mysql", "3389/tcp": "ms-wbt-server",
+    "4369/tcp": "epmd", "5060/tcp": "sip", "5060/udp": "sip", "5222/tcp": "xmpp-client", "5353/udp": "mdns",
+    "5432/tcp": "postgresql", "5672/tcp": "amqp", "5900/tcp": "rfb", "5984/tcp": "couchdb", "6379/tcp": "redis",
+    "6443/tcp": "sun-sr-https", "6667/tcp": "ircd", "8080/tcp": "http-alt", "8443/tcp": "https-alt",
+    "9042/tcp": "cassandra", "9092/tcp": "kafka", "9200/tcp": "wap-wsp", "11211/tcp": "memcache",
+    "27017/tcp": "mongodb",
+}
+
+var (
+    servicesOnce sync.Once
+    services     map[string]string
+)
+
+// servicesPath is the system services database for this platform.
+func servicesPath() string {
+    if runtime.GOOS == "windows" {
+        return filepath.Join(os.Getenv("SystemRoot"), "System32", "drivers", "etc", "services")
+    }
+    return "/etc/services"
+}
+
+// parseServices reads "name port/protocol [aliases] [# comment]" lines,
+// keeping the first name listed for each port.
+func parseServices(r io.Reader) map[string]string {
+    names := map[string]string{}
+    scanner := bufio.NewScanner(r)
+    for scanner.Scan() {
+        line := scanner.Text()
+        if i := strings.Index(line, "#"); i >= 0 {
+            line = line[:i]
+        }
+        fields := strings.Fields(line)
+        if len(fields) < 2 {
+            continue
+        }
+        key := strings.ToLower(fields[1])
+        if _, ok := names[key]; !ok {
+            names[key] = fields[0]
+        }
+    }
+    return names
+}
+
+// serviceName returns the conventional name of a port, from the system
+// services file when it exists (read once) and the embedded table otherwise.
+func serviceName(port int, protocol string) string {
+    servicesOnce.Do(func() {
+        services = embeddedServices
+        if file, err := os.Open(servicesPath()); err == nil {
+            defer file.Close()
+            if names := parseServices(file); len(names) > 0 {
+                services = names
+            }
+        }
+    })
+    return services[fmt.Sprintf("%d/%s", port, protocol)]
+}
+
+// newResolver returns a resolver that sends every query to server
+// ("host:port", port 53 when omitted) instead of the system configuration.
+func newResolver(server string) *net.Resolver {
+    if _, _, err := net.SplitHostPort(server); err != nil {
+        server = net.JoinHostPort(server, "53")
+    }
+    return &net.Resolver{
+        PreferGo: true,
+        Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+            dialer := net.Dialer{}
+            return dialer.DialContext(ctx, network, server)
+        },
+    }
+}
+
+// dialTCP opens a TCP connection to host:port, through cfg.Proxy unless the
+// host is excluded by NO_PROXY.
+func dialTCP(ctx context.Context, host string, port int, cfg Config) (net.Conn, error) {
+    dialer := net.Dialer{Timeout: cfg.Timeout, Resolver: cfg.Resolver}
+    address := net.JoinHostPort(host, strconv.Itoa(port))
+    if cfg.Proxy != nil && !cfg.Proxy.bypass(host) {
+        return cfg.Proxy.dial(ctx, &dialer, address)
+    }
+    return dialer.DialContext(ctx, "tcp", net.JoinHostPort(cfg.address(host), strconv.Itoa(port)))
+}
+
+// bandwidthLimiter caps the bytes per second shared by every throttled
+// connection. Each transfer books its share of time on a common schedule and
+// sleeps until its slot, so the average stays under the rate without bursts.
+type bandwidthLimiter struct {
+    mu   sync.Mutex
+    rate float64
+    next time.Time
+}
+
+func newBandwidthLimiter(bytesPerSecond int64) *bandwidthLimiter {
+    return &bandwidthLimiter{rate: float64(bytesPerSecond)}
+}
+
+// wait books n bytes and sleeps until the transfer's turn.
+func (l *bandwidthLimiter) wait(n int) {
+    time.Sleep(l.book(n))
+}
+
+// book adds n bytes to the schedule and returns how long to wait before
+// sending them.
+func (l *bandwidthLimiter) book(n int) time.Duration {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    now := time.Now()
+    if l.next.Before(now) {
+        l.next = now
+    }
+    delay := l.next.Sub(now)
+    l.next = l.next.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+    return delay
+}
+
+// chunk bounds a single write so one large transfer cannot take a quarter
+// second or more of budget at once.
+func (l *bandwidthLimiter) chunk(n int) int {
+    limit := int(l.rate / 4)
+    if limit < 1 {
+        limit = 1
+    }
+    if n > limit {
+        return limit
+    }
+    return n
+}
+
+type throttledConn struct {
+    net.Conn
+    limiter *bandwidthLimiter
+}
+
+// Read cannot know the size of a reply in advance, so the time it used is
+// paid back before the next transfer on any connection.
+func (c *throttledConn) Read(p []byte) (int, error) {
+    c.limiter.wait(0)
+    n, err := c.Conn.Read(p)
+    c.limiter.book(n)
+    return n, err
+}
+
+func (c *throttledConn) Write(p []byte) (int, error) {
+    written := 0
+    for written < len(p) {
+        n := c.limiter.chunk(len(p) - written)
+        c.limiter.wait(n)
+        n, err := c.Conn.Write(p[written : written+n])
+        written += n
+        if err != nil {
+            return written, err
+        }
+    }
+    return written, nil
+}
+
+// throttle applies -max-bandwidth to connections that carry data (banners,
+// TLS and HTTP). Plain connect probes are left alone.
+func throttle(conn net.Conn, cfg Config) net.Conn {
+    if cfg.Bandwidth == nil {
+        return conn
+    }
+    return &throttledConn{Conn: conn, limiter: cfg.Bandwidth}
+}
+
+// parseByteSize reads sizes such as "500", "64KB", "1MB" or "2G", with
+// binary (1024-based) units.
+func parseByteSize(value string) (int64, error) {
+    number := strings.ToUpper(strings.TrimSpace(value))
+    number = strings.TrimSuffix(strings.TrimSuffix(number, "B"), "I")
+    multiplier := int64(1)
+    if number != "" {
+        switch number[len(number)-1] {
+        case 'K':
+            multiplier = 1 << 10
+        case 'M':
+            multiplier = 1 << 20
+        case 'G':
+            multiplier = 1 << 30
+        }
+        if multiplier > 1 {
+            number = number[:len(number)-1]
+        }
+    }
+    size, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+    if err != nil || size <= 0 {
+        return 0, fmt.Errorf("invalid size %q", value)
+    }
+    return int64(size * float64(multiplier)), nil
+}
+
+func checkHostAlive(ctx context.Context, host string, port int, cfg Config) bool {
+    _, err := connectTCP(ctx, host, port, cfg)
+    return err == nil
+}
+
+// errResetAfterConnect marks a port that completed the handshake and was
+// reset straight away, which is what middleboxes injecting RSTs tend to
+// produce, as opposed to a refused SYN.
+var errResetAfterConnect = errors.New("connection reset right after connect")
+
+// resetCheckDelay is how long connectTCP watches a new connection for a reset
+// when reasons are requested.
+const resetCheckDelay = 25 * time.Millisecond
+
+// connectTCP is the connect scan probe: nil or errResetAfterConnect means
+// the port accepted. With cfg.Reasons it also briefly reads from the
+// connection to catch an immediate reset. source is the local address of
+// an accepted connection. With cfg.AbortOpen the connection is torn down
+// with a RST rather than the usual FIN.
+func connectTCP(ctx context.Context, host string, port int, cfg Config) (source string, err error) {
+    conn, err := dialTCP(ctx, host, port, cfg)
+    if errors.Is(err, syscall.ECONNRESET) {
+        // The handshake completed but the reset beat the dial's return.
+        return "", errResetAfterConnect
+    }
+    if err != nil {
+        return "", err
+    }
+    if tcpConn, ok := conn.(*net.TCPConn); ok && cfg.AbortOpen && (cfg.Proxy == nil || cfg.Proxy.bypass(host)) {
+        tcpConn.SetLinger(0)
+    }
+    defer conn.Close()
+    source = conn.LocalAddr().String()
+    if cfg.Reasons {
+        conn.SetReadDeadline(time.Now().Add(resetCheckDelay))
+        var b [1]byte
+        if _, err := conn.Read(b[:]); errors.Is(err, syscall.ECONNRESET) {
+            return source, errResetAfterConnect
+        }
+    }
+    return source, nil
+}
+
+// connectReason describes the evidence behind a connect probe's state.
+func connectReason(err error, host string, cfg Config) string {
+    var netErr net.Error
+    var reply *socksReplyError
+    switch {
+    case err == nil:
+        return "syn-ack"
+    case errors.Is(err, errResetAfterConnect):
+        return "reset-after-connect (possible injected RST)"
+    case cfg.Proxy != nil && !cfg.Proxy.bypass(host):
+        if errors.As(err, &reply) && socksReplies[reply.code] != "" {
+            return "proxy-" + socksReplies[reply.code]
+        }
+        return "proxy-error"
+    case errors.Is(err, syscall.ECONNREFUSED):
+        return "conn-refused"
+    case errors.Is(err, syscall.EHOSTUNREACH):
+        return "host-unreach"
+    case errors.Is(err, syscall.ENETUNREACH):
+        return "net-unreach"
+    case errors.As(err, &netErr) && netErr.Timeout():
+        return "no-response"
+    }
+    return "error"
+}
+
+// stateReason is the reason for probes that only report a state: raw
+// socket scans and UDP.
+func stateReason(protocol, state string) string {
+    switch {
+    case protocol == "udp" && state == "open":
+        return "udp-response"
+    case state == "open":
+        return "syn-ack"
+    case state == "closed", state == "unfiltered":
+        return "reset"
+    case state == "filtered", state == "open|filtered":
+        return "no-response"
+    }
+    return ""
+}
+
+// retryable reports whether a failed connect is worth another attempt:
+// timeouts and local resource exhaustion may succeed later, while a refused
+// or unreachable port is a definite answer.
+func retryable(err error) bool {
+    var netErr net.Error
+    if errors.As(err, &netErr) && netErr.Timeout() {
+        return true
+    }
+    for _, errno := range []syscall.Errno{syscall.EMFILE, syscall.ENFILE, syscall.EAGAIN, syscall.ENOBUFS, syscall.EADDRNOTAVAIL, syscall.ECONNRESET} {
+        if errors.Is(err, errno) {
+            return true
+        }
+    }
+    return false
+}
+
+// infrastructureError names err when it is a failure of the scanning host
+// or its network rather than an answer from the target: local resources
+// running out, no route to the network, or an error not classified at all.
+// It returns "" for answers and timeouts. An unreachable or down host and a
+// connection aborted during the handshake are the target's answer too.
+func infrastructureError(err error) string {
+    var netErr net.Error
+    switch {
+    case err == nil, errors.Is(err, errResetAfterConnect), errors.Is(err, syscall.ECONNREFUSED),
+        errors.Is(err, syscall.ECONNABORTED), errors.Is(err, syscall.EHOSTUNREACH),
+        errors.Is(err, syscall.EHOSTDOWN), errors.Is(err, context.Canceled):
+        return ""
+    case errors.As(err, &netErr) && netErr.Timeout():
+        return ""
+    }
+    for _, errno := range []syscall.Errno{syscall.EMFILE, syscall.ENFILE, syscall.EAGAIN, syscall.ENOBUFS, syscall.EADDRNOTAVAIL, syscall.ENETUNREACH} {
+        if errors.Is(err, errno) {
+            return errno.Error()
+        }
+    }
+    return "other error"
+}
+
+// probeErrors counts the connect probes that failed with an
+// infrastructureError, by name.
+type probeErrors struct {
+    mu     sync.Mutex
+    counts map[string]int
+}
+
+func newProbeErrors() *probeErrors {
+    return &probeErrors{counts: make(map[string]int)}
+}
+
+func (e *probeErrors) note(err error) {
+    if e == nil {
+        return
+    }
+    if name := infrastructureError(err); name != "" {
+        e.mu.Lock()
+        e.counts[name]++
+        e.mu.Unlock()
+    }
+}
+
+// snapshot returns a copy of the counts, or nil if there were none.
+func (e *probeErrors) snapshot() map[string]int {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+    if len(e.counts) == 0 {
+        return nil
+    }
+    counts := make(map[string]int, len(e.counts))
+    for name, n := range e.counts {
+        counts[name] = n
+    }
+    return counts
+}
+
+// retryBudget caps the retries of a whole scan (-max-retries-total).
+type retryBudget struct {
+    mu   sync.Mutex
+    left int
+}
+
+func newRetryBudget(total int) *retryBudget {
+    if total <= 0 {
+        return nil
+    }
+    return &retryBudget{left: total}
+}
+
+// take uses up one retry, reporting false once none are left. A nil budget
+// is unlimited.
+func (b *retryBudget) take() bool {
+    if b == nil {
+        return true
+    }
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    if b.left == 0 {
+        return false
+    }
+    b.left--
+    return true
+}
+
+// Retries back off exponentially from retryBaseDelay, doubling per attempt
+// up to retryMaxDelay, and retryJitter of the delay is added or taken away
+// at random so the retries against a target do not go out in lockstep.
+const (
+    retryBaseDelay = 100 * time.Millisecond
+    retryMaxDelay  = 2 * time.Second
+    retryJitter    = 0.25
+)
+
+// retryDelay is the wait before retry number attempt+1. rng is the scan's
+// seeded generator, so the delays repeat with -seed; nil means no jitter.
+func retryDelay(attempt int, rng *lockedRand) time.Duration {
+    delay := retryMaxDelay
+    if attempt < 16 && retryBaseDelay<<uint(attempt) < retryMaxDelay {
+        delay = retryBaseDelay << uint(attempt)
+    }
+    if rng != nil {
+        spread := int64(float64(delay) * retryJitter)
+        delay += time.Duration(rng.Int63n(2*spread+1) - spread)
+    }
+    return delay
+}
+
+// connectWithRetries runs the connect probe up to cfg.Retries more times
+// when it fails in a retryable way and the scan's retry budget allows,
+// backing off with retryDelay between attempts. It returns the last
+// attempt's source address and error, nil when the port accepted.
+func connectWithRetries(ctx context.Context, host string, port int, cfg Config) (string, error) {
+    for attempt := 0; ; attempt++ {
+        source, err := connectTCP(ctx, host, port, cfg)
+        if err == nil || attempt >= cfg.Retries || !retryable(err) || ctx.Err() != nil || !cfg.retries.take() {
+            return source, err
+        }
+        select {
+        case <-time.After(retryDelay(attempt, cfg.rng)):
+        case <-ctx.Done():
+            return source, err
+        }
+    }
+}
+
+// noRouteLimit is how many connect probes may fail with "network
+// unreachable" or "no route to host", before anything has answered, until
+// the scan gives up on the targets as unroutable.
+const noRouteLimit = 64
+
+// scanAbort stops a whole scan early: hosts not yet started are skipped
+// and probes in flight end as not scanned. Its context also ends with the
+// one the scan was started with.
+type scanAbort struct {
+    ctx    context.Context
+    cancel context.CancelFunc
+
+    mu       sync.Mutex
+    reason   string
+    noRoute  int
+    answered bool
+}
+
+func newScanAbort(parent context.Context) *scanAbort {
+    ctx, cancel := context.WithCancel(parent)
+    return &scanAbort{ctx: ctx, cancel: cancel}
+}
+
+// stop aborts the scan; the first reason given is kept.
+func (a *scanAbort) stop(reason string) {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    if a.reason == "" {
+        a.reason = reason
+        a.cancel()
+    }
+}
+
+// stopped returns why the scan was aborted, or "" while it is running.
+func (a *scanAbort) stopped() string {
+    if a == nil {
+        return ""
+    }
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    return a.reason
+}
+
+// noteConnect tracks routing failures of connect probes, aborting the scan
+// once noRouteLimit of them have come and no probe has been answered. Once
+// a port has accepted or refused a connection there is a route to part of
+// the targets and single unreachable hosts are no reason to stop. Timeouts
+// and other errors are no answer: with a short -t a probe often times out
+// before "no route to host" comes back, so they neither count nor stop the
+// count.
+func (a *scanAbort) noteConnect(err error) {
+    if a == nil {
+        return
+    }
+    a.mu.Lock()
+    if a.answered {
+        a.mu.Unlock()
+        return
+    }
+    if err == nil || errors.Is(err, syscall.ECONNREFUSED) {
+        a.answered = true
+        a.mu.Unlock()
+        return
+    }
+    if !errors.Is(err, syscall.ENETUNREACH) && !errors.Is(err, syscall.EHOSTUNREACH) {
+        a.mu.Unlock()
+        return
+    }
+    a.noRoute++
+    count := a.noRoute
+    a.mu.Unlock()
+    if count >= noRouteLimit {
+        var errno syscall.Errno
+        errors.As(err, &errno)
+        a.stop(fmt.Sprintf("%d probes failed with %q and none got an answer; check there is a route to the targets", count, errno.Error()))
+    }
+}
+
+// rttSampleHosts is how many answering hosts timeoutCheck waits for before
+// judging the timeout.
+const rttSampleHosts = 5
+
+// timeoutCheck takes the time of the first connect answer from a few hosts
+// and warns once if their median is over half the connect timeout. Answers
+// that arrived are faster than the timeout by definition, but when a typical
+// one takes most of it the slower replies are lost and their ports reported
+// as filtered.
+type timeoutCheck struct {
+    mu      sync.Mutex
+    timeout time.Duration
+    hosts   map[string]bool
+    samples []time.Duration
+    checked bool
+}
+
+func newTimeoutCheck(timeout time.Duration) *timeoutCheck {
+    return &timeoutCheck{timeout: timeout, hosts: make(map[string]bool)}
+}
+
+func (c *timeoutCheck) note(host string, rtt time.Duration) {
+    if c == nil {
+        return
+    }
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    // An answer only after a retry says more about the retry than the RTT.
+    if c.checked || c.hosts[host] || rtt >= c.timeout {
+        return
+    }
+    c.hosts[host] = true
+    c.samples = append(c.samples, rtt)
+    if len(c.samples) >= rttSampleHosts {
+        c.checkLocked()
+    }
+}
+
+// check judges whatever samples there are, for scans that end before
+// rttSampleHosts hosts have answered.
+func (c *timeoutCheck) check() {
+    if c == nil {
+        return
+    }
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.checkLocked()
+}
+
+func (c *timeoutCheck) checkLocked() {
+    if c.checked || len(c.samples) == 0 {
+        return
+    }
+    c.checked = true
+    sort.Slice(c.samples, func(i, j int) bool { return c.samples[i] < c.samples[j] })
+    median := c.samples[len(c.samples)/2]
+    if median > c.timeout/2 {
+        fmt.Printf("[!] Answering hosts take about %v to reply, over half the %v connect timeout; slower replies will be missed and reported as filtered, consider a higher -t\n", median.Round(time.Millisecond), c.timeout)
+    }
+}
+
+// connectState maps a connect probe's error to a port state: a timeout or
+// an ICMP unreachable means something dropped or rejected the probe
+// (filtered), anything else, such as a refusal, means closed. Through a
+// SOCKS proxy only the proxy's "connection refused" reply means closed;
+// every other failure, the proxy's own included, counts as filtered, so a
+// failing proxy does not make hosts look up.
+func connectState(err error, host string, cfg Config) string {
+    var reply *socksReplyError
+    switch {
+    case err == nil, errors.Is(err, errResetAfterConnect):
+        return "open"
+    case cfg.Proxy != nil && !cfg.Proxy.bypass(host):
+        if errors.As(err, &reply) && reply.code == socksConnectionRefused {
+            return "closed"
+        }
+        return "filtered"
+    case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+        return "filtered"
+    }
+    var netErr net.Error
+    if errors.As(err, &netErr) && netErr.Timeout() {
+        return "filtered"
+    }
+    return "closed"
+}
+
+// ScanPort makes a single TCP connect probe of host:port and returns the
+// port's state ("open", "closed" or "filtered") with the reason for it. The
+// error is only for bad arguments or a ctx that ended before an answer; a
+// closed or filtered port is a result, not an error. A zero timeout leaves
+// the dial bounded only by ctx.
+func ScanPort(ctx context.Context, host string, port int, timeout time.Duration) (PortResult, error) {
+    result := PortResult{Port: port, Protocol: "tcp"}
+    if host == "" {
+        return result, errors.New("empty host")
+    }
+    if port < 1 || port > 65535 {
+        return result, fmt.Errorf("port %d out of range 1-65535", port)
+    }
+    cfg := Config{Timeout: timeout, Reasons: true}
+    _, err := connectTCP(ctx, host, port, cfg)
+    if err != nil && ctx.Err() != nil {
+        return result, ctx.Err()
+    }
+    result.State = connectState(err, host, cfg)
+    result.Reason = connectReason(err, host, cfg)
+    result.Service = serviceName(port, "tcp")
+    return result, nil
+}
+
+// udpProbe is a protocol-specific payload that makes a UDP service answer,
+// with a minimal check that the reply really is that protocol.
+type udpProbe struct {
+    name    string
+    payload []byte
+    match   func(reply []byte) bool
+}
+
+// dnsReplyTo checks the reply echoes the 0x4852 query ID and has QR set.
+func dnsReplyTo(reply []byte) bool {
+    return len(reply) >= 12 && reply[0] == 0x48 && reply[1] == 0x52 && reply[2]&0x80 != 0
+}
+
+var udpProbes = map[int]udpProbe{
+    // Standard query for the root NS records.
+    53: {"dns", []byte{0x48, 0x52, 0x01, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+        0x00, 0x00, 0x02, 0x00, 0x01}, dnsReplyTo},
+    // NTPv3 client request; the server answers in mode 4.
+    123: {"ntp", append([]byte{0x1b}, make([]byte, 47)...), func(reply []byte) bool {
+        return len(reply) >= 48 && reply[0]&0x07 == 4
+    }},
+    // NetBIOS node status (NBSTAT) query for the wildcard name "*".
+    137: {"netbios", append(append([]byte{0x48, 0x52, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x20},
+        []byte("CKAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA")...), 0x00, 0x00, 0x21, 0x00, 0x01), dnsReplyTo},
+    // SNMPv1 get-request for sysDescr.0 with the "public" community.
+    161: {"snmp", []byte{0x30, 0x26, 0x02, 0x01, 0x00, 0x04, 0x06, 'p', 'u', 'b', 'l', 'i', 'c',
+        0xa0, 0x19, 0x02, 0x01, 0x01, 0x02, 0x01, 0x00, 0x02, 0x01, 0x00, 0x30, 0x0e, 0x30, 0x0c,
+        0x06, 0x08, 0x2b, 0x06, 0x01, 0x02, 0x01, 0x01, 0x01, 0x00, 0x05, 0x00}, func(reply []byte) bool {
+        return len(reply) > 2 && reply[0] == 0x30
+    }},
+    // mDNS PTR query for _services._dns-sd._udp.local, sent from a port other
+    // than 5353 so responders answer unicast.
+    5353: {"mdns", append(append([]byte{0x48, 0x52, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+        []byte("\x09_services\x07_dns-sd\x04_udp\x05local\x00")...), 0x00, 0x0c, 0x00, 0x01), dnsReplyTo},
+}
+
+// checkUDPPort sends the known probe for the port (or an empty datagram) and
+// waits for a reply. A reply means open, and names the probe when it parses
+// as the expected protocol. An ICMP port unreachable surfaces as a read error
+// (ECONNREFUSED) and means closed, while silence is indistinguishable from a
+// firewall drop.
+func checkUDPPort(ctx context.Context, host string, port int, cfg Config) (string, string) {
+    dialer := net.Dialer{Timeout: cfg.Timeout, Resolver: cfg.Resolver}
+    conn, err := dialer.DialContext(ctx, "udp", net.JoinHostPort(cfg.address(host), strconv.Itoa(port)))
+    if err != nil {
+        return "closed", ""
+    }
+    defer conn.Close()
+    deadline := time.Now().Add(cfg.Timeout)
+    if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+        deadline = ctxDeadline
+    }
+    conn.SetDeadline(deadline)
+    probe, known := udpProbes[port]
+    if _, err := conn.Write(probe.payload); err != nil {
+        return "closed", ""
+    }
+    buf := make([]byte, 1024)
+    n, err := conn.Read(buf)
+    if err != nil {
+        if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+            return "open|filtered", ""
+        }
+        return "closed", ""
+    }
+    if known && probe.match(buf[:n]) {
+        return "open", probe.name
+    }
+    return "open", ""
+}
+
+// bannerIdle ends a banner once the service has sent something and then
+// paused this long, so a one-line banner does not wait out the read timeout.
+const bannerIdle = 200 * time.Millisecond
+
+// grabBanner reads whatever the service sends first, up to cfg.BannerBytes
+// (1024 when unset), and reports whether there was more. Reads go on across
+// TCP segments until the limit, the end of the stream, the read timeout or
+// a bannerIdle pause. Services that wait for the client to speak (HTTP,
+// TLS) produce an empty banner.
+func grabBanner(ctx context.Context, host string, port int, cfg Config) (string, bool) {
+    conn, err := dialTCP(ctx, host, port, cfg)
+    if err != nil {
+        return "", false
+    }
+    defer conn.Close()
+    conn = throttle(conn, cfg)
+    deadline := time.Now().Add(cfg.readTimeout())
+    conn.SetReadDeadline(deadline)
+    limit := cfg.BannerBytes
+    if limit <= 0 {
+        limit = 1024
+    }
+    // One extra byte tells a banner that filled the limit from a longer one.
+    buf := make([]byte, limit+1)
+    n := 0
+    for n < len(buf) {
+        read, err := conn.Read(buf[n:])
+        n += read
+        if err != nil {
+            break
+        }
+        if idle := time.Now().Add(bannerIdle); idle.Before(deadline) {
+            conn.SetReadDeadline(idle)
+        }
+    }
+    if n > limit {
+        return string(buf[:limit]), true
+    }
+    return string(buf[:n]), false
+}
+
+// sanitizeBanner makes a banner safe to print on a terminal: printable text
+// is kept, common control characters are written as \r, \n and \t, and any
+// other byte (including invalid UTF-8) as \xNN.
+func sanitizeBanner(banner string) string {
+    var b strings.Builder
+    for i := 0; i < len(banner); {
+        r, size := utf8.DecodeRuneInString(banner[i:])
+        switch {
+        case r == '\r':
+            b.WriteString(`\r`)
+        case r == '\n':
+            b.WriteString(`\n`)
+        case r == '\t':
+            b.WriteString(`\t`)
+        case r == '\\':
+            b.WriteString(`\\`)
+        case r == utf8.RuneError && size <= 1, !unicode.IsPrint(r):
+            for _, c := range []byte(banner[i : i+size]) {
+                fmt.Fprintf(&b, `\x%02x`, c)
+            }
+        default:
+            b.WriteRune(r)
+        }
+        i += size
+    }
+    return b.String()
+}
+
+func grabTLS(ctx context.Context, host string, port int, cfg Config) *TLSInfo {
+    serverName := ""
+    if net.ParseIP(host) == nil {
+        serverName = host
+    }
+    return grabTLSWithSNI(ctx, host, port, serverName, cfg)
+}
+
+// grabTLSWithSNI handshakes presenting serverName as SNI and describes the
+// leaf certificate the server chose for it.
+func grabTLSWithSNI(ctx context.Context, host string, port int, serverName string, cfg Config) *TLSInfo {
+    tlsConfig := &tls.Config{InsecureSkipVerify: true, ServerName: serverName}
+    state, ok := tlsHandshake(ctx, host, port, cfg, tlsConfig)
+    if !ok || len(state.PeerCertificates) == 0 {
+        return nil
+    }
+    cert := state.PeerCertificates[0]
+    sum := sha256.Sum256(cert.Raw)
+    return &TLSInfo{
+        Subject:     cert.Subject.String(),
+        Issuer:      cert.Issuer.String(),
+        NotAfter:    cert.NotAfter,
+        Fingerprint: hex.EncodeToString(sum[:]),
+    }
+}
+
+// newHTTPClient returns the client used for HTTP inspection. It dials
+// through dialTCP so the proxy settings apply, and does not verify
+// certificates since scanned services rarely have valid ones. Keep-alives
+// are off so every request is made on a fresh connection, like the port
+// probes, and no answer depends on what an earlier request left behind.
+func newHTTPClient(cfg Config) *http.Client {
+    transport := &http.Transport{
+        DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+            host, portStr, err := net.SplitHostPort(address)
+            if err != nil {
+                return nil, err
+            }
+            port, _ := strconv.Atoi(portStr)
+            conn, err := dialTCP(ctx, host, port, cfg)
+            if err != nil {
+                return nil, err
+            }
+            return throttle(conn, cfg), nil
+        },
+        TLSClientConfig:     &tls.Config{InsecureSkipVerify: true},
+        DisableKeepAlives:     true,
+        TLSHandshakeTimeout:   cfg.readTimeout(),
+        ResponseHeaderTimeout: cfg.readTimeout(),
+    }
+    return &http.Client{Transport: transport, Timeout: cfg.Timeout + 3*cfg.readTimeout()}
+}
+
+// fetchHTTP GETs path over plain HTTP and then HTTPS, returning the body of
+// the first 200 response (capped at 1 MiB). Any other plain HTTP answer
+// still tries HTTPS: TLS ports often answer plain requests with an error
+// page, such as nginx's 400 for "plain HTTP request sent to HTTPS port".
+func fetchHTTP(ctx context.Context, client *http.Client, host string, port int, path string) ([]byte, bool) {
+    for _, scheme := range []string{"http", "https"} {
+        target := fmt.Sprintf("%s://%s%s", scheme, net.JoinHostPort(host, strconv.Itoa(port)), path)
+        req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+        if err != nil {
+            return nil, false
+        }
+        resp, err := client.Do(req)
+        if err != nil {
+            continue
+        }
+        body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+        resp.Body.Close()
+        if err == nil && resp.StatusCode == http.StatusOK {
+            return body, true
+        }
+    }
+    return nil, false
+}
+
+// grabHTTP sends cfg.HTTPMethod for cfg.HTTPPath over plain HTTP and then
+// HTTPS, and describes the first answer of either. A plain HTTP 400 is
+// what TLS ports commonly send back ("plain HTTP request sent to HTTPS
+// port"), so HTTPS is still tried and its answer preferred.
+func grabHTTP(ctx context.Context, client *http.Client, host string, port int, cfg Config) *HTTPInfo {
+    noRedirects := *client
+    noRedirects.CheckRedirect = func(*http.Request, []*http.Request) error {
+        return http.ErrUseLastResponse
+    }
+    var plain *HTTPInfo
+    for _, scheme := range []string{"http", "https"} {
+        target := fmt.Sprintf("%s://%s%s", scheme, net.JoinHostPort(host, strconv.Itoa(port)), cfg.HTTPPath)
+        req, err := http.NewRequestWithContext(ctx, cfg.HTTPMethod, target, nil)
+        if err != nil {
+            return nil
+        }
+        resp, err := noRedirects.Do(req)
+        if err != nil {
+            continue
+        }
+        body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+        resp.Body.Close()
+        info := &HTTPInfo{
+            Scheme:   scheme,
+            Status:   resp.StatusCode,
+            Server:   sanitizeBanner(resp.Header.Get("Server")),
+            Location: sanitizeBanner(resp.Header.Get("Location")),
+            Title:    htmlTitle(body),
+        }
+        if scheme == "http" && resp.StatusCode == http.StatusBadRequest {
+            plain = info
+            continue
+        }
+        return info
+    }
+    return plain
+}
+
+// htmlTitle returns the text of the page's <title> element, unescaped and
+// with whitespace collapsed.
+func htmlTitle(page []byte) string {
+    // Only ASCII is folded, so offsets in lower are offsets in page:
+    // strings.ToLower would grow invalid UTF-8 and some runes.
+    folded := make([]byte, len(page))
+    for i, c := range page {
+        if 'A' <= c && c <= 'Z' {
+            c += 'a' - 'A'
+        }
+        folded[i] = c
+    }
+    lower := string(folded)
+    start := strings.Index(lower, "<title")
+    if start < 0 {
+        return ""
+    }
+    open := strings.IndexByte(lower[start:], '>')
+    if open < 0 {
+        return ""
+    }
+    start += open + 1
+    end := strings.Index(lower[start:], "</title")
+    if end < 0 {
+        return ""
+    }
+    title := html.UnescapeString(string(page[start : start+end]))
+    return sanitizeBanner(strings.Join(strings.Fields(title), " "))
+}
+
+// grabFaviconHash returns the hash Shodan indexes as http.favicon.hash:
+// MurmurHash3 of the MIME-style (line-wrapped) base64 of the icon.
+func grabFaviconHash(ctx context.Context, client *http.Client, host string, port int) *int32 {
+    body, ok := fetchHTTP(ctx, client, host, port, "/favicon.ico")
+    if !ok || len(body) == 0 {
+        return nil
+    }
+    encoded := base64.StdEncoding.EncodeToString(body)
+    var wrapped strings.Builder
+    for len(encoded) > 76 {
+        wrapped.WriteString(encoded[:76])
+        wrapped.WriteByte('\n')
+        encoded = encoded[76:]
+    }
+    wrapped.WriteString(encoded)
+    wrapped.WriteByte('\n')
+    hash := int32(murmur3([]byte(wrapped.String()), 0))
+    return &hash
+}
+
+// murmur3 is the 32-bit x86 variant of MurmurHash3.
+func murmur3(data []byte, seed uint32) uint32 {
+    const c1, c2 = 0xcc9e2d51, 0x1b873593
+    h := seed
+    n := len(data) / 4
+    for i := 0; i < n; i++ {
+        k := binary.LittleEndian.Uint32(data[i*4:])
+        k *= c1
+        k = k<<15 | k>>17
+        k *= c2
+        h ^= k
+        h = h<<13 | h>>19
+        h = h*5 + 0xe6546b64
+    }
+    var k uint32
+    tail := data[n*4:]
+    switch len(tail) {
+    case 3:
+        k ^= uint32(tail[2]) << 16
+        fallthrough
+    case 2:
+        k ^= uint32(tail[1]) << 8
+        fallthrough
+    case 1:
+        k ^= uint32(tail[0])
+        k *= c1
+        k = k<<15 | k>>17
+        k *= c2
+        h ^= k
+    }
+    h ^= uint32(len(data))
+    h ^= h >> 16
+    h *= 0x85ebca6b
+    h ^= h >> 13
+    h *= 0xc2b2ae35
+    h ^= h >> 16
+    return h
+}
+
+// hostnameCache remembers the names found for each IP during a scan, and
+// makes concurrent lookups of the same IP share a single query.
+type hostnameCache struct {
+    mu      sync.Mutex
+    names   map[string]string
+    pending map[string]chan struct{}
+}
+
+func newHostnameCache() *hostnameCache {
+    return &hostnameCache{names: map[string]string{}, pending: map[string]chan struct{}{}}
+}
+
+// lookup returns the cached name for ip, calling find once if there is
+// none yet. A nil cache always calls find.
+func (c *hostnameCache) lookup(ip string, find func() string) string {
+    if c == nil {
+        return find()
+    }
+    c.mu.Lock()
+    for {
+        if name, ok := c.names[ip]; ok {
+            c.mu.Unlock()
+            return name
+        }
+        wait, ok := c.pending[ip]
+        if !ok {
+            break
+        }
+        c.mu.Unlock()
+        <-wait
+        c.mu.Lock()
+    }
+    done := make(chan struct{})
+    c.pending[ip] = done
+    c.mu.Unlock()
+    name := find()
+    c.mu.Lock()
+    c.names[ip] = name
+    delete(c.pending, ip)
+    c.mu.Unlock()
+    close(done)
+    return name
+}
+
+// lookupHostname finds a name for ip via PTR, falling back to a unicast
+// mDNS reverse query and then a NetBIOS node status query. Results are
+// kept in cfg.hostnames for the rest of the scan.
+func lookupHostname(ip string, cfg Config) string {
+    return cfg.hostnames.lookup(ip, func() string {
+        return findHostname(ip, cfg)
+    })
+}
+
+func findHostname(ip string, cfg Config) string {
+    resolver := cfg.Resolver
+    if resolver == nil {
+        resolver = net.DefaultResolver
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), cfg.dnsTimeout())
+    defer cancel()
+    if names, err := resolver.LookupAddr(ctx, ip); err == nil && len(names) > 0 {
+        return strings.TrimSuffix(names[0], ".")
+    }
+    if name := lookupMDNSName(ip, cfg.Timeout); name != "" {
+        return name
+    }
+    return lookupNetBIOSName(ip, cfg.Timeout)
+}
+
+// udpExchange sends one datagram and returns the first reply.
+func udpExchange(ip string, port int, payload []byte, timeout time.Duration) []byte {
+    conn, err := net.DialTimeout("udp", net.JoinHostPort(ip, strconv.Itoa(port)), timeout)
+    if err != nil {
+        return nil
+    }
+    defer conn.Close()
+    conn.SetDeadline(time.Now().Add(timeout))
+    if _, err := conn.Write(payload); err != nil {
+        return nil
+    }
+    buf := make([]byte, 1500)
+    n, err := conn.Read(buf)
+    if err != nil {
+        return nil
+    }
+    return buf[:n]
+}
+
+func lookupMDNSName(ip string, timeout time.Duration) string {
+    parsed := net.ParseIP(ip).To4()
+    if parsed == nil {
+        return ""
+    }
+    query := []byte{0x48, 0x52, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+    for i := 3; i >= 0; i-- {
+        label := strconv.Itoa(int(parsed[i]))
+        query = append(query, byte(len(label)))
+        query = append(query, label...)
+    }
+    query = append(query, "\x07in-addr\x04arpa\x00"...)
+    query = append(query, 0x00, 0x0c, 0x00, 0x01)
+    reply := udpExchange(ip, 5353, query, timeout)
+    if !dnsReplyTo(reply) {
+        return ""
+    }
+    return firstPTRAnswer(reply)
+}
+
+// firstPTRAnswer returns the target of the first PTR record in a DNS reply.
+func firstPTRAnswer(msg []byte) string {
+    questions := int(binary.BigEndian.Uint16(msg[4:6]))
+    answers := int(binary.BigEndian.Uint16(msg[6:8]))
+    off := 12
+    for i := 0; i < questions; i++ {
+        _, next, ok := readDNSName(msg, off)
+        if !ok {
+            return ""
+        }
+        off = next + 4
+    }
+    for i := 0; i < answers; i++ {
+        _, next, ok := readDNSName(msg, off)
+        if !ok || next+10 > len(msg) {
+            return ""
+        }
+        rrType := binary.BigEndian.Uint16(msg[next : next+2])
+        length := int(binary.BigEndian.Uint16(msg[next+8 : next+10]))
+        off = next + 10
+        if rrType == 12 {
+            name, _, ok := readDNSName(msg, off)
+            if ok {
+                return strings.TrimSuffix(name, ".")
+            }
+            return ""
+        }
+        off += length
+    }
+    return ""
+}
+
+// readDNSName decodes a possibly compressed name at off, returning it and the
+// offset just past it in the original position.
+func readDNSName(msg []byte, off int) (string, int, bool) {
+    labels := []string{}
+    end := -1
+    for jumps := 0; jumps < 16; {
+        if off >= len(msg) {
+            return "", 0, false
+        }
+        length := int(msg[off])
+        switch {
+        case length == 0:
+            if end < 0 {
+                end = off + 1
+            }
+            return strings.Join(labels, ".") + ".", end, true
+        case length&0xc0 == 0xc0:
+            if off+1 >= len(msg) {
+                return "", 0, false
+            }
+            if end < 0 {
+                end = off + 2
+            }
+            off = int(binary.BigEndian.Uint16(msg[off:off+2]) & 0x3fff)
+            jumps++
+        default:
+            if off+1+length > len(msg) {
+                return "", 0, false
+            }
+            labels = append(labels, string(msg[off+1:off+1+length]))
+            off += 1 + length
+        }
+    }
+    return "", 0, false
+}
+
+// lookupNetBIOSName sends a node status query and returns the first unique
+// workstation (suffix 0x00) name in the reply.
+func lookupNetBIOSName(ip string, timeout time.Duration) string {
+    reply := udpExchange(ip, 137, udpProbes[137].payload, timeout)
+    // Header (12) + echoed name (34) + type, class, TTL and length (10).
+    const namesOffset = 56
+    if !dnsReplyTo(reply) || len(reply) <= namesOffset {
+        return ""
+    }
+    count := int(reply[namesOffset])
+    for i := 0; i < count; i++ {
+        entry := namesOffset + 1 + i*18
+        if entry+18 > len(reply) {
+            break
+        }
+        suffix := reply[entry+15]
+        group := reply[entry+16]&0x80 != 0
+        if suffix == 0x00 && !group {
+            return strings.TrimSpace(string(reply[entry : entry+15]))
+        }
+    }
+    return ""
+}
+
+// socksProxy is a minimal SOCKS5 client (RFC 1928, with RFC 1929
+// username/password auth) used to route connect scans through a proxy.
+type socksProxy struct {
+    addr     string
+    username string
+    password string
+    noProxy  []string
+}
+
+// proxyFromEnvironment builds a proxy from ALL_PROXY (or a socks5 HTTP_PROXY)
+// and NO_PROXY. It returns nil when no proxy is configured.
+func proxyFromEnvironment() (*socksProxy, error) {
+    raw := ""
+    for _, name := range []string{"ALL_PROXY", "all_proxy", "HTTP_PROXY", "http_proxy"} {
+        if value := os.Getenv(name); value != "" {
+            if strings.HasPrefix(name, "HTTP") && !strings.HasPrefix(value, "socks5") {
+                continue
+            }
+            raw = value
+            break
+        }
+    }
+    if raw == "" {
+        return nil, nil
+    }
+    proxyURL, err := url.Parse(raw)
+    if err != nil {
+        return nil, err
+    }
+    if proxyURL.Scheme != "socks5" && proxyURL.Scheme != "socks5h" {
+        return nil, fmt.Errorf("unsupported proxy scheme %q, only socks5 is supported", proxyURL.Scheme)
+    }
+    proxy := &socksProxy{addr: proxyURL.Host}
+    if proxyURL.User != nil {
+        proxy.username = proxyURL.User.Username()
+        proxy.password, _ = proxyURL.User.Password()
+    }
+    noProxy := os.Getenv("NO_PROXY")
+    if noProxy == "" {
+        noProxy = os.Getenv("no_proxy")
+    }
+    for _, entry := range strings.Split(noProxy, ",") {
+        if entry = strings.ToLower(strings.TrimSpace(entry)); entry != "" {
+            proxy.noProxy = append(proxy.noProxy, entry)
+        }
+    }
+    return proxy, nil
+}
+
+// bypass reports whether NO_PROXY excludes host. Entries may be "*", an IP,
+// a CIDR or a domain, which also matches its subdomains.
+func (p *socksProxy) bypass(host string) bool {
+    host = strings.ToLower(host)
+    ip := net.ParseIP(host)
+    for _, entry := range p.noProxy {
+        switch {
+        case entry == "*" || entry == host:
+            return true
+        case strings.Contains(entry, "/"):
+            if _, ipNet, err := net.ParseCIDR(entry); err == nil && ip != nil && ipNet.Contains(ip) {
+                return true
+            }
+        case ip == nil:
+            if strings.HasSuffix(host, "."+strings.TrimPrefix(entry, ".")) {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// socksConnectionRefused is the SOCKS5 reply code for a target that
+// refused the connection, the only reply that shows the port is closed.
+const socksConnectionRefused = 0x05
+
+// socksReplies names the SOCKS5 failure reply codes (RFC 1928).
+var socksReplies = map[byte]string{
+    0x01: "general-failure",
+    0x02: "not-allowed",
+    0x03: "net-unreach",
+    0x04: "host-unreach",
+    0x05: "conn-refused",
+    0x06: "ttl-expired",
+    0x07: "command-not-supported",
+    0x08: "address-type-not-supported",
+}
+
+// socksReplyError is a SOCKS5 proxy's refusal to connect to address.
+type socksReplyError struct {
+    address string
+    code    byte
+}
+
+func (e *socksReplyError) Error() string {
+    if name := socksReplies[e.code]; name != "" {
+        return fmt.Sprintf("socks: connect to %s failed: %s", e.address, name)
+    }
+    return fmt.Sprintf("socks: connect to %s failed with code %d", e.address, e.code)
+}
+
+func (p *socksProxy) dial(ctx context.Context, dialer *net.Dialer, address string) (net.Conn, error) {
+    conn, err := dialer.DialContext(ctx, "tcp", p.addr)
+    if err != nil {
+        return nil, err
+    }
+    deadline := time.Now().Add(dialer.Timeout)
+    if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+        deadline = ctxDeadline
+    }
+    conn.SetDeadline(deadline)
+    if err := p.handshake(conn, address); err != nil {
+        conn.Close()
+        return nil, err
+    }
+    conn.SetDeadline(time.Time{})
+    return conn, nil
+}
+
+func (p *socksProxy) handshake(conn net.Conn, address string) error {
+    methods := []byte{0x00}
+    if p.username != "" {
+        methods = append(methods, 0x02)
+    }
+    if _, err := conn.Write(append([]byte{0x05, byte(len(methods))}, methods...)); err != nil {
+        return err
+    }
+    reply := make([]byte, 2)
+    if _, err := io.ReadFull(conn, reply); err != nil {
+        return err
+    }
+    switch reply[1] {
+    case 0x00:
+    case 0x02:
+        auth := []byte{0x01, byte(len(p.username))}
+        auth = append(auth, p.username...)
+        auth = append(auth, byte(len(p.password)))
+        auth = append(auth, p.password...)
+        if _, err := conn.Write(auth); err != nil {
+            return err
+        }
+        if _, err := io.ReadFull(conn, reply); err != nil {
+            return err
+        }
+        if reply[1] != 0x00 {
+            return errors.New("socks: authentication failed")
+        }
+    default:
+        return errors.New("socks: no acceptable authentication method")
+    }
+
+    host, portStr, err := net.SplitHostPort(address)
+    if err != nil {
+        return err
+    }
+    port, _ := strconv.Atoi(portStr)
+    request := []byte{0x05, 0x01, 0x00}
+    if ip := net.ParseIP(host); ip == nil {
+        request = append(request, 0x03, byte(len(host)))
+        request = append(request, host...)
+    } else if ip4 := ip.To4(); ip4 != nil {
+        request = append(request, 0x01)
+        request = append(request, ip4...)
+    } else {
+        request = append(request, 0x04)
+        request = append(request, ip.To16()...)
+    }
+    request = binary.BigEndian.AppendUint16(request, uint16(port))
+    if _, err := conn.Write(request); err != nil {
+        return err
+    }
+    header := make([]byte, 4)
+    if _, err := io.ReadFull(conn, header); err != nil {
+        return err
+    }
+    if header[1] != 0x00 {
+        return &socksReplyError{address: address, code: header[1]}
+    }
+    // Skip the bound address the proxy reports back.
+    skip := 0
+    switch header[3] {
+    case 0x01:
+        skip = net.IPv4len
+    case 0x04:
+        skip = net.IPv6len
+    case 0x03:
+        length := make([]byte, 1)
+        if _, err := io.ReadFull(conn, length); err != nil {
+            return err
+        }
+        skip = int(length[0])
+    }
+    _, err = io.ReadFull(conn, make([]byte, skip+2))
+    return err
+}
+
+const (
+    tcpFIN = 0x01
+    tcpSYN = 0x02
+    tcpRST = 0x04
+    tcpPSH = 0x08
+    tcpACK = 0x10
+    tcpURG = 0x20
+)
+
+// rawScanFlags maps each raw scan type to the TCP flags its probe carries.
+var rawScanFlags = map[string]byte{
+    "syn":  tcpSYN,
+    "fin":  tcpFIN,
+    "null": 0,
+    "xmas": tcpFIN | tcpPSH | tcpURG,
+    "ack":  tcpACK,
+}
+
+// rawScanner sends hand-built TCP segments over a raw IPv4 socket and hands
+// each reply to the probe waiting on that address and port. Only IPv4 is
+// supported, and opening the socket needs root or CAP_NET_RAW.
+type rawScanner struct {
+    conn     net.PacketConn
+    resolver *net.Resolver
+    srcPort  uint16
+    mu      sync.Mutex
+    pending map[string]chan []byte
+    // decoys are sent from spoof, a socket that takes whole IP packets so
+    // the source address can be forged.
+    decoys []net.IP
+    spoof  net.PacketConn
+}
+
+func newRawScanner(resolver *net.Resolver, decoys []net.IP) (*rawScanner, error) {
+    conn, err := net.ListenPacket("ip4:tcp", "0.0.0.0")
+    if err != nil {
+        return nil, err
+    }
+    if resolver == nil {
+        resolver = net.DefaultResolver
+    }
+    scanner := &rawScanner{
+        conn:     conn,
+        resolver: resolver,
+        srcPort:  uint16(32768 + rand.Intn(28232)),
+        pending:  make(map[string]chan []byte),
+    }
+    if len(decoys) > 0 {
+        // Protocol 255 (IPPROTO_RAW) implies IP_HDRINCL on Linux: the kernel
+        // sends the IP header we write, source address included.
+        scanner.spoof, err = net.ListenPacket("ip4:255", "0.0.0.0")
+        if err != nil {
+            conn.Close()
+            return nil, fmt.Errorf("decoys: %v", err)
+        }
+        scanner.decoys = decoys
+    }
+    go scanner.receive()
+    return scanner, nil
+}
+
+func (s *rawScanner) Close() error {
+    if s.spoof != nil {
+        s.spoof.Close()
+    }
+    return s.conn.Close()
+}
+
+func (s *rawScanner) receive() {
+    buf := make([]byte, 1500)
+    for {
+        n, addr, err := s.conn.ReadFrom(buf)
+        if err != nil {
+            return
+        }
+        if n < 20 || binary.BigEndian.Uint16(buf[2:4]) != s.srcPort {
+            continue
+        }
+        key := fmt.Sprintf("%s:%d", addr.(*net.IPAddr).IP, binary.BigEndian.Uint16(buf[0:2]))
+        s.mu.Lock()
+        ch := s.pending[key]
+        s.mu.Unlock()
+        if ch != nil {
+            segment := make([]byte, 20)
+            copy(segment, buf[:20])
+            select {
+            case ch <- segment:
+            default:
+            }
+        }
+    }
+}
+
+// probe sends one segment with the given flags and returns the reply's TCP
+// header, or nil if nothing came back within the timeout. A SYN/ACK is
+// answered with a RST so no half-open connection is left on the target.
+func (s *rawScanner) probe(ctx context.Context, host string, port int, flags byte, timeout time.Duration) ([]byte, error) {
+    ips, err := s.resolver.LookupIP(ctx, "ip4", host)
+    if err != nil {
+        return nil, err
+    }
+    dst := ips[0].To4()
+    dstAddr := &net.IPAddr{IP: dst}
+    src, err := localIPFor(dst)
+    if err != nil {
+        return nil, err
+    }
+    key := fmt.Sprintf("%s:%d", dst, port)
+    ch := make(chan []byte, 1)
+    s.mu.Lock()
+    s.pending[key] = ch
+    s.mu.Unlock()
+    defer func() {
+        s.mu.Lock()
+        delete(s.pending, key)
+        s.mu.Unlock()
+    }()
+
+    seq := rand.Uint32()
+    segment := buildTCPSegment(src, dst, s.srcPort, uint16(port), seq, 0, flags)
+    if err := s.send(segment, dst, port, flags); err != nil {
+        return nil, err
+    }
+    timer := time.NewTimer(timeout)
+    defer timer.Stop()
+    select {
+    case reply := <-ch:
+        if reply[13]&(tcpSYN|tcpACK) == tcpSYN|tcpACK {
+            rst := buildTCPSegment(src, dst, s.srcPort, uint16(port), seq+1, 0, tcpRST)
+            s.conn.WriteTo(rst, dstAddr)
+        }
+        return reply, nil
+    case <-timer.C:
+        return nil, nil
+    case <-ctx.Done():
+        return nil, ctx.Err()
+    }
+}
+
+// send writes the probe segment, surrounded by the decoy probes when there
+// are any. The decoys' replies go to the spoofed addresses, so only the
+// real probe is waited for.
+func (s *rawScanner) send(segment []byte, dst net.IP, port int, flags byte) error {
+    dstAddr := &net.IPAddr{IP: dst}
+    if len(s.decoys) == 0 {
+        _, err := s.conn.WriteTo(segment, dstAddr)
+        return err
+    }
+    for _, decoy := range s.decoys {
+        if decoy == nil {
+            if _, err := s.conn.WriteTo(segment, dstAddr); err != nil {
+                return err
+            }
+            continue
+        }
+        decoySegment := buildTCPSegment(decoy, dst, s.srcPort, uint16(port), rand.Uint32(), 0, flags)
+        if _, err := s.spoof.WriteTo(buildIPv4Packet(decoy, dst, decoySegment), dstAddr); err != nil {
+            return fmt.Errorf("decoy %s: %v", decoy, err)
+        }
+    }
+    return nil
+}
+
+// scan probes one port and interprets the reply for the scan type. For SYN
+// a SYN/ACK means open, a RST closed and silence filtered. FIN, NULL and
+// XMAS rely on RFC 793: a closed port answers with a RST while an open one
+// stays silent, so silence is only "open|filtered". Windows (and some other
+// stacks) send a RST regardless, so every port looks closed there. ACK
+// cannot tell open from closed at all; a RST only shows that the probe got
+// through ("unfiltered"), which maps out firewall rules.
+func (s *rawScanner) scan(ctx context.Context, host string, port int, scanType string, timeout time.Duration) string {
+    reply, err := s.probe(ctx, host, port, rawScanFlags[scanType], timeout)
+    if scanType == "ack" {
+        if err != nil || reply == nil || reply[13]&tcpRST == 0 {
+            return "filtered"
+        }
+        return "unfiltered"
+    }
+    switch {
+    case err != nil || reply == nil:
+        if scanType == "syn" {
+            return "filtered"
+        }
+        return "open|filtered"
+    case reply[13]&tcpRST != 0:
+        return "closed"
+    case reply[13]&(tcpSYN|tcpACK) == tcpSYN|tcpACK:
+        return "open"
+    default:
+        return "filtered"
+    }
+}
+
+// localIPFor returns the source address the kernel would route dst from.
+func localIPFor(dst net.IP) (net.IP, error) {
+    conn, err := net.Dial("udp4", net.JoinHostPort(dst.String(), "9"))
+    if err != nil {
+        return nil, err
+    }
+    defer conn.Close()
+    return conn.LocalAddr().(*net.UDPAddr).IP.To4(), nil
+}
+
+func buildTCPSegment(src, dst net.IP, srcPort, dstPort uint16, seq, ack uint32, flags byte) []byte {
+    segment := make([]byte, 20)
+    binary.BigEndian.PutUint16(segment[0:2], srcPort)
+    binary.BigEndian.PutUint16(segment[2:4], dstPort)
+    binary.BigEndian.PutUint32(segment[4:8], seq)
+    binary.BigEndian.PutUint32(segment[8:12], ack)
+    segment[12] = 5 << 4
+    segment[13] = flags
+    binary.BigEndian.PutUint16(segment[14:16], 1024)
+
+    pseudo := make([]byte, 0, 12+len(segment))
+    pseudo = append(pseudo, src...)
+    pseudo = append(pseudo, dst...)
+    pseudo = append(pseudo, 0, 6)
+    pseudo = binary.BigEndian.AppendUint16(pseudo, uint16(len(segment)))
+    pseudo = append(pseudo, segment...)
+    binary.BigEndian.PutUint16(segment[16:18], checksum(pseudo))
+    return segment
+}
+
+// buildIPv4Packet prepends an IPv4 header from src to dst to a TCP segment.
+func buildIPv4Packet(src, dst net.IP, segment []byte) []byte {
+    packet := make([]byte, 20, 20+len(segment))
+    packet[0] = 4<<4 | 5
+    binary.BigEndian.PutUint16(packet[2:4], uint16(20+len(segment)))
+    binary.BigEndian.PutUint16(packet[4:6], uint16(rand.Intn(1<<16)))
+    packet[8] = 64
+    packet[9] = 6
+    copy(packet[12:16], src.To4())
+    copy(packet[16:20], dst.To4())
+    binary.BigEndian.PutUint16(packet[10:12], checksum(packet))
+    return append(packet, segment...)
+}
+
+func checksum(data []byte) uint16 {
+    var sum uint32
+    for i := 0; i+1 < len(data); i += 2 {
+        sum += uint32(data[i])<<8 | uint32(data[i+1])
+    }
+    if len(data)%2 == 1 {
+        sum += uint32(data[len(data)-1]) << 8
+    }
+    for sum>>16 != 0 {
+        sum = sum&0xffff + sum>>16
+    }
+    return ^uint16(sum)
+}
+
+var tlsVersionNames = map[uint16]string{
+    tls.VersionTLS10: "TLS1.0",
+    tls.VersionTLS11: "TLS1.1",
+    tls.VersionTLS12: "TLS1.2",
+    tls.VersionTLS13: "TLS1.3",
+}
+
+// weakTLSVersions are flagged in the summary. crypto/tls cannot speak SSLv3,
+// so it is never detected.
+var weakTLSVersions = map[string]bool{"TLS1.0": true, "TLS1.1": true}
+
+func tlsHandshake(ctx context.Context, host string, port int, cfg Config, tlsConfig *tls.Config) (tls.ConnectionState, bool) {
+    rawConn, err := dialTCP(ctx, host, port, cfg)
+    if err != nil {
+        return tls.ConnectionState{}, false
+    }
+    conn := tls.Client(throttle(rawConn, cfg), tlsConfig)
+    defer conn.Close()
+    ctx, cancel := context.WithTimeout(ctx, cfg.readTimeout())
+    defer cancel()
+    if err := conn.HandshakeContext(ctx); err != nil {
+        return tls.ConnectionState{}, false
+    }
+    return conn.ConnectionState(), true
+}
+
+// enumerateTLS lists the TLS versions and cipher suites a port accepts.
+// TLS 1.3 suites cannot be chosen by the client in crypto/tls, so only the
+// one the server negotiated is recorded for it.
+func enumerateTLS(ctx context.Context, host string, port int, cfg Config) ([]string, []string) {
+    serverName := ""
+    if net.ParseIP(host) == nil {
+        serverName = host
+    }
+    suites := append(tls.CipherSuites(), tls.InsecureCipherSuites()...)
+    versions, ciphers := []string{}, []string{}
+    seen := make(map[string]bool)
+    for _, version := range []uint16{tls.VersionTLS10, tls.VersionTLS11, tls.VersionTLS12, tls.VersionTLS13} {
+        newConfig := func() *tls.Config {
+            return &tls.Config{InsecureSkipVerify: true, ServerName: serverName, MinVersion: version, MaxVersion: version}
+        }
+        if version == tls.VersionTLS13 {
+            state, ok := tlsHandshake(ctx, host, port, cfg, newConfig())
+            if ok {
+                versions = append(versions, tlsVersionNames[version])
+                ciphers = append(ciphers, tls.CipherSuiteName(state.CipherSuite))
+            }
+            continue
+        }
+        supported := false
+        for _, suite := range suites {
+            if !suiteSupportsVersion(suite, version) {
+                continue
+            }
+            tlsConfig := newConfig()
+            tlsConfig.CipherSuites = []uint16{suite.ID}
+            if _, ok := tlsHandshake(ctx, host, port, cfg, tlsConfig); ok {
+                supported = true
+                if !seen[suite.Name] {
+                    seen[suite.Name] = true
+                    ciphers = append(ciphers, suite.Name)
+                }
+            }
+        }
+        if supported {
+            versions = append(versions, tlsVersionNames[version])
+        }
+    }
+    return versions, ciphers
+}
+
+func suiteSupportsVersion(suite *tls.CipherSuite, version uint16) bool {
+    for _, v := range suite.SupportedVersions {
+        if v == version {
+            return true
+        }
+    }
+    return false
+}
+
+// probePool runs probes on a fixed set of goroutines when -max-goroutines
+// is set. A nil pool starts a goroutine per probe.
+type probePool struct {
+    jobs chan func()
+}
+
+// goroutineReserve is what -max-goroutines keeps back for main, the signal
+// and key watchers, the checkpoint flusher and the like.
+const goroutineReserve = 8
+
+// probePoolSize is what is left of maxGoroutines for probes once the host
+// workers, one probe dispatcher per host worker and the reserve are taken.
+func probePoolSize(maxGoroutines, hostWorkers int) int {
+    return maxGoroutines - 2*hostWorkers - goroutineReserve
+}
+
+// flatPoolSize is what is left of maxGoroutines for the -flat pool once the
+// reserve and the two goroutines per host with probes in flight, at most
+// one host per probe, are taken.
+func flatPoolSize(maxGoroutines int) int {
+    return (maxGoroutines - goroutineReserve) / 3
+}
+
+func newProbePool(size int) *probePool {
+    pool := &probePool{jobs: make(chan func())}
+    for i := 0; i < size; i++ {
+        go func() {
+            for job := range pool.jobs {
+                job()
+            }
+        }()
+    }
+    return pool
+}
+
+// run hands job to the pool. Once ctx has ended it stops waiting for a free
+// worker and starts job on its own goroutine, where the probe ends at once
+// as not scanned.
+func (p *probePool) run(ctx context.Context, job func()) {
+    if p == nil {
+        go job()
+        return
+    }
+    select {
+    case p.jobs <- job:
+    case <-ctx.Done():
+        go job()
+    }
+}
+
+func (p *probePool) close() {
+    if p != nil {
+        close(p.jobs)
+    }
+}
+
+// Adaptive scheduling (-adaptive) shares a fixed budget of in-flight probes
+// between hosts. Each host has a window that grows while its probes answer
+// quickly and halves when they time out, so unresponsive hosts hold few
+// slots and the budget flows to hosts that are answering.
+const (
+    adaptiveStartWindow = 32
+    adaptiveMinWindow   = 8
+    adaptiveMaxWindow   = 512
+)
+
+// hostWindow is a semaphore whose size changes with the host's latency.
+// Waiters queue in order and are woken one by one as slots free up.
+type hostWindow struct {
+    mu       sync.Mutex
+    limit    float64
+    max      float64
+    inFlight int
+    waiters  []chan struct{}
+}
+
+func newHostWindow(maxConns int) *hostWindow {
+    w := &hostWindow{limit: adaptiveStartWindow, max: adaptiveMaxWindow}
+    if maxConns > 0 && float64(maxConns) < w.max {
+        w.max = float64(maxConns)
+    }
+    if w.limit > w.max {
+        w.limit = w.max
+    }
+    return w
+}
+
+func (w *hostWindow) acquire(ctx context.Context) bool {
+    w.mu.Lock()
+    if len(w.waiters) == 0 && w.inFlight < int(w.limit) {
+        w.inFlight++
+        w.mu.Unlock()
+        return true
+    }
+    granted := make(chan struct{})
+    w.waiters = append(w.waiters, granted)
+    w.mu.Unlock()
+    select {
+    case <-granted:
+        return true
+    case <-ctx.Done():
+    }
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    for i, waiter := range w.waiters {
+        if waiter == granted {
+            w.waiters = append(w.waiters[:i], w.waiters[i+1:]...)
+            return false
+        }
+    }
+    // The slot was granted as ctx ended; hand it on.
+    w.inFlight--
+    w.grant()
+    return false
+}
+
+// grant wakes queued waiters while the window has room. w.mu must be held.
+func (w *hostWindow) grant() {
+    for len(w.waiters) > 0 && w.inFlight < int(w.limit) {
+        w.inFlight++
+        close(w.waiters[0])
+        w.waiters = w.waiters[1:]
+    }
+}
+
+// release returns a slot and adjusts the window: additive increase for a
+// probe answered within a quarter of the timeout, multiplicative decrease
+// for one that timed out.
+func (w *hostWindow) release(rtt, timeout time.Duration, timedOut bool) {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    w.inFlight--
+    switch {
+    case timedOut:
+        w.limit /= 2
+        if w.limit < adaptiveMinWindow {
+            w.limit = adaptiveMinWindow
+        }
+        if w.limit > w.max {
+            w.limit = w.max
+        }
+    case rtt < timeout/4 && w.limit < w.max:
+        w.limit++
+    }
+    w.grant()
+}
+
+// acquireProbe takes a slot from the host window and the global budget when
+// adaptive scheduling is on. It returns false if ctx ended first; otherwise
+// the caller must call the release function with the probe's outcome.
+func (cfg Config) acquireProbe(ctx context.Context) (func(rtt time.Duration, timedOut bool), bool) {
+    if cfg.window == nil {
+        return func(time.Duration, bool) {}, true
+    }
+    if !cfg.window.acquire(ctx) {
+        return nil, false
+    }
+    select {
+    case cfg.probeBudget <- struct{}{}:
+    case <-ctx.Done():
+        cfg.window.release(0, cfg.Timeout, false)
+        return nil, false
+    }
+    return func(rtt time.Duration, timedOut bool) {
+        <-cfg.probeBudget
+        cfg.window.release(rtt, cfg.Timeout, timedOut)
+    }, true
+}
+
+func scanPort(ctx context.Context, host string, port int, protocol string, cfg Config, results chan PortResult, wg *sync.WaitGroup) {
+    defer wg.Done()
+    if result, ok := cfg.Cache.lookup(host, port, protocol, cfg.enrichments()); ok {
+        if cfg.Checkpoint != nil {
+            if result.State == "closed" || result.State == "filtered" {
+                cfg.Checkpoint.finish(host, port, protocol, nil)
+            } else {
+                cfg.Checkpoint.finish(host, port, protocol, &result)
+            }
+        }
+        results <- result
+        return
+    }
+    cfg.pause.wait(ctx)
+    if cfg.Jitter > 0 {
+        select {
+        case <-time.After(time.Duration(cfg.rng.Int63n(int64(cfg.Jitter) + 1))):
+        case <-ctx.Done():
+        }
+    }
+    state, probe, reason, source := "closed", "", "", ""
+    if release, ok := cfg.acquireProbe(ctx); ok {
+        probeStart := time.Now()
+        switch protocol {
+        case "udp":
+            state, probe = checkUDPPort(ctx, host, port, cfg)
+            reason = stateReason(protocol, state)
+        default:
+            if raw := cfg.rawFor(host); raw != nil {
+                state = raw.scan(ctx, cfg.address(host), port, cfg.ScanType, cfg.Timeout)
+                reason = stateReason(protocol, state)
+            } else {
+                var err error
+                source, err = connectWithRetries(ctx, host, port, cfg)
+                if ctx.Err() == nil {
+                    cfg.abort.noteConnect(err)
+                    if cfg.Proxy == nil || cfg.Proxy.bypass(host) {
+                        cfg.probeErrors.note(err)
+                    }
+                }
+                state, reason = connectState(err, host, cfg), connectReason(err, host, cfg)
+                if state == "open" || state == "closed" {
+                    cfg.rtt.note(host, time.Since(probeStart))
+                }
+            }
+        }
+        release(time.Since(probeStart), state == "filtered" || state == "open|filtered")
+    }
+    if state != "open" && ctx.Err() != nil {
+        state = "not-scanned"
+    }
+    // Only report ports that answered; silently dropped probes would
+    // otherwise list every firewalled port, so filtered ones are passed on
+    // bare for scanHost to count, as are closed ones, which show the host is up.
+    if state == "closed" || state == "filtered" {
+        if cfg.Checkpoint != nil {
+            cfg.Checkpoint.finish(host, port, protocol, nil)
+        }
+        result := PortResult{Port: port, Protocol: protocol, State: state}
+        cfg.Cache.store(host, result, nil)
+        results <- result
+        return
+    }
+    result := PortResult{Port: port, Protocol: protocol, State: state, Service: serviceName(port, protocol), Probe: probe}
+    if protocol == "tcp" {
+        result.ScanType = "connect"
+        if cfg.rawFor(host) != nil {
+            result.ScanType = cfg.ScanType
+        }
+    }
+    if cfg.Reasons && state != "not-scanned" {
+        result.Reason = reason
+    }
+    if cfg.ShowSource {
+        result.Source = source
+    }
+    if state == "open" && protocol == "tcp" {
+        if cfg.Banners {
+            result.Banner, result.BannerTruncated = grabBanner(ctx, host, port, cfg)
+        }
+        if cfg.TLSInspect {
+            result.TLS = grabTLS(ctx, host, port, cfg)
+        }
+        if cfg.Favicon {
+            result.FaviconHash = grabFaviconHash(ctx, cfg.httpClient, host, port)
+        }
+        if cfg.HTTP {
+            result.HTTP = grabHTTP(ctx, cfg.httpClient, host, port, cfg)
+        }
+        if cfg.TLSEnum {
+            result.TLSVersions, result.TLSCiphers = enumerateTLS(ctx, host, port, cfg)
+        }
+        for _, name := range cfg.SNINames {
+            if info := grabTLSWithSNI(ctx, host, port, name, cfg); info != nil {
+                if result.SNICerts == nil {
+                    result.SNICerts = make(map[string]*TLSInfo)
+                }
+                result.SNICerts[name] = info
+            }
+        }
+    }
+    if cfg.Checkpoint != nil && state != "not-scanned" {
+        cfg.Checkpoint.finish(host, port, protocol, &result)
+    }
+    if state != "not-scanned" {
+        cfg.Cache.store(host, result, cfg.enrichments())
+    }
+    results <- result
+}
+
+// hostScan is the state of one host's scan: its context, its copy of the
+// config and the channel its probes report on.
+type hostScan struct {
+    host    string
+    ports   protocolPorts
+    cfg     Config
+    ctx     context.Context
+    cancel  context.CancelFunc
+    start   time.Time
+    // slots caps the probes in flight against this host at once; with
+    // adaptive scheduling the host window does that instead.
+    slots   chan struct{}
+    results chan PortResult
+    wg      sync.WaitGroup
+}
+
+func newHostScan(host string, ports protocolPorts, cfg Config) *hostScan {
+    h := &hostScan{host: host, ports: ports, start: time.Now(), results: make(chan PortResult)}
+    ctx := context.Background()
+    if cfg.abort != nil {
+        ctx = cfg.abort.ctx
+    }
+    if cfg.HostTimeout > 0 {
+        h.ctx, h.cancel = context.WithTimeout(ctx, cfg.HostTimeout)
+    } else {
+        h.ctx, h.cancel = context.WithCancel(ctx)
+    }
+    if cfg.probeBudget != nil {
+        cfg.window = newHostWindow(cfg.MaxConnsPerHost)
+    }
+    if cfg.MaxConnsPerHost > 0 && cfg.window == nil {
+        h.slots = make(chan struct{}, cfg.MaxConnsPerHost)
+    }
+    h.cfg = cfg
+    return h
+}
+
+// pending reports whether port still needs probing, which it does not if a
+// resumed checkpoint already has it.
+func (h *hostScan) pending(port int, protocol string) bool {
+    return h.cfg.Checkpoint == nil || !h.cfg.Checkpoint.completed(h.host, port, protocol)
+}
+
+// cancelled reports port as not scanned, without starting a probe for it,
+// once the host's context has ended. The caller must be handing out the
+// host's probes, so that h.results is still open.
+func (h *hostScan) cancelled(port int, protocol string) bool {
+    if h.ctx.Err() == nil {
+        return false
+    }
+    h.results <- PortResult{Port: port, Protocol: protocol, State: "not-scanned"}
+    return true
+}
+
+// probe waits for a slot on the host and runs one probe. The caller must
+// have added it to h.wg.
+func (h *hostScan) probe(port int, protocol string) {
+    if h.slots != nil {
+        select {
+        case h.slots <- struct{}{}:
+            defer func() { <-h.slots }()
+        case <-h.ctx.Done():
+            // scanPort reports the probe as not scanned.
+        }
+    }
+    scanPort(h.ctx, h.host, port, protocol, h.cfg, h.results, &h.wg)
+}
+
+func scanHost(host string, ports protocolPorts, cfg Config) HostResult {
+    h := newHostScan(host, ports, cfg)
+    defer h.cancel()
+    ctx, cfg := h.ctx, h.cfg
+    // Probes are handed out from their own goroutine so a bounded probe
+    // pool can make this wait without holding up the results below.
+    go func() {
+        for _, protocol := range cfg.Protocols {
+            for _, port := range ports[protocol] {
+                if !h.pending(port, protocol) || h.cancelled(port, protocol) {
+                    continue
+                }
+                port, protocol := port, protocol
+                h.wg.Add(1)
+                if cfg.Sequential {
+                    scanPort(ctx, host, port, protocol, cfg, h.results, &h.wg)
+                    continue
+                }
+                if h.slots != nil && cfg.PreserveOrder {
+                    // Take the slot before starting the probe so probes go
+                    // out in list order rather than whichever goroutine wins.
+                    select {
+                    case h.slots <- struct{}{}:
+                        cfg.pool.run(ctx, func() {
+                            defer func() { <-h.slots }()
+                            scanPort(ctx, host, port, protocol, cfg, h.results, &h.wg)
+                        })
+                    case <-ctx.Done():
+                        cfg.pool.run(ctx, func() {
+                            scanPort(ctx, host, port, protocol, cfg, h.results, &h.wg)
+                        })
+                    }
+                    continue
+                }
+                cfg.pool.run(ctx, func() { h.probe(port, protocol) })
+            }
+        }
+        h.wg.Wait()
+        close(h.results)
+    }()
+    return h.collect()
+}
+
+// collect reads the host's probe results until the channel is closed and
+// sums them up.
+func (h *hostScan) collect() HostResult {
+    host, cfg := h.host, h.cfg
+    openPorts := []PortResult{}
+    if cfg.Checkpoint != nil {
+        openPorts = append(openPorts, cfg.Checkpoint.restored[host]...)
+    }
+    notScanned, filtered, closed := 0, 0, 0
+    for result := range h.results {
+        switch result.State {
+        case "not-scanned":
+            notScanned++
+        case "filtered":
+            filtered++
+        case "closed":
+            closed++
+        default:
+            openPorts = append(openPorts, result)
+        }
+    }
+    elapsed := time.Since(h.start)
+    state := hostState(openPorts, closed, filtered)
+    if cfg.Verbose {
+        switch {
+        case len(openPorts) > 0:
+            fmt.Printf("%s is %s\n", host, state)
+            details := []string{}
+            for _, port := range openPorts {
+                details = append(details, port.Detail())
+            }
+            fmt.Printf("%s has open ports: [%s] (%v)\n", host, strings.Join(details, ", "), elapsed)
+        case state == "up":
+            fmt.Printf("%s is up, no open ports (%v)\n", host, elapsed)
+        case state == "down":
+            fmt.Printf("%s is down or filtered, no probe got a response (%v)\n", host, elapsed)
+        default:
+            fmt.Printf("%s state unknown, nothing was probed (%v)\n", host, elapsed)
+        }
+        // h.ctx also ends when the whole scan is interrupted or aborted.
+        hostTimedOut := cfg.HostTimeout > 0 && errors.Is(h.ctx.Err(), context.DeadlineExceeded) &&
+            (cfg.abort == nil || cfg.abort.ctx.Err() == nil)
+        switch {
+        case notScanned > 0 && hostTimedOut:
+            fmt.Printf("%s exceeded host timeout, %d probe(s) not scanned\n", host, notScanned)
+        case notScanned > 0:
+            fmt.Printf("%s scan interrupted, %d probe(s) not scanned\n", host, notScanned)
+        }
+    }
+    open := 0
+    for _, port := range openPorts {
+        if port.State == "open" {
+            open++
+        }
+    }
+    probed := h.ports.count()
+    return HostResult{
+        Host:       normalizeHost(host),
+        State:      state,
+        Ports:      openPorts,
+        Open:       open,
+        Probed:     probed,
+        Filtered:   filtered,
+        Elapsed:    elapsed,
+        TimedOut:   notScanned > 0,
+        NotScanned: notScanned,
+        LikelyHoneypot: likelyHoneypot(open, probed),
+    }
+}
+
+// A host is flagged as a likely honeypot when more than honeypotOpenRatio of
+// at least honeypotMinProbed probes found the port open.
+const (
+    honeypotOpenRatio = 0.95
+    honeypotMinProbed = 20
+)
+
+func likelyHoneypot(open, probed int) bool {
+    return probed >= honeypotMinProbed && float64(open) > honeypotOpenRatio*float64(probed)
+}
+
+// hostState decides whether a host is up from how its probes ended, not
+// from whether any port was open: a refusal or reset proves the host is
+// there as much as an accepted connection does. "down" means every probe
+// went unanswered (timeouts and unreachables), "unknown" that no probe
+// finished, as when the host timeout hit first.
+func hostState(ports []PortResult, closed, filtered int) string {
+    for _, port := range ports {
+        if port.State != "open|filtered" {
+            return "up"
+        }
+    }
+    switch {
+    case closed > 0:
+        return "up"
+    case filtered > 0 || len(ports) > 0:
+        return "down"
+    }
+    return "unknown"
+}
+
+// checkpoint records which (host, port) probes have finished so a crashed or
+// interrupted scan can be rerun without repeating them. The file is plain
+// text and append-only:
+//
+//   done <host> <protocol> <ports>   finished probes, as ranges ("1-1024,3306")
+//   port <host> <json>               a reported PortResult, banners included
+//
+// Finished probes are batched and written as ranges once a second, so at
+// most the last second of work is repeated after a crash. A port line is
+// written at once and also marks its probe done, so a reported port is
+// never both restored and probed again.
+type checkpoint struct {
+    mu       sync.Mutex
+    file     *os.File
+    done     map[string]bool
+    restored map[string][]PortResult
+    pending  map[string]map[string][]int
+    stop     chan struct{}
+    stopped  chan struct{}
+}
+
+func checkpointKey(host string, port int, protocol string) string {
+    return fmt.Sprintf("%s %d/%s", host, port, protocol)
+}
+
+// openCheckpoint loads the probes already finished in path, if it exists,
+// and opens it to record the rest.
+func openCheckpoint(path string) (*checkpoint, error) {
+    cp := &checkpoint{
+        done:     map[string]bool{},
+        restored: map[string][]PortResult{},
+        pending:  map[string]map[string][]int{},
+        stop:     make(chan struct{}),
+        stopped:  make(chan struct{}),
+    }
+    if data, err := os.ReadFile(path); err == nil {
+        for n, line := range strings.Split(string(data), "\n") {
+            fields := strings.SplitN(line, " ", 3)
+            switch {
+            case line == "":
+            case len(fields) == 3 && fields[0] == "done":
+                protocol := strings.SplitN(fields[2], " ", 2)
+                if len(protocol) != 2 {
+                    return nil, fmt.Errorf("%s:%d: malformed line", path, n+1)
+                }
+                ports, err := parsePorts(protocol[1], false)
+                if err != nil {
+                    return nil, fmt.Errorf("%s:%d: %v", path, n+1, err)
+                }
+                for _, port := range ports {
+                    cp.done[checkpointKey(fields[1], port, protocol[0])] = true
+                }
+            case len(fields) == 3 && fields[0] == "port":
+                var result PortResult
+                if err := json.Unmarshal([]byte(fields[2]), &result); err != nil {
+                    return nil, fmt.Errorf("%s:%d: %v", path, n+1, err)
+                }
+                key := checkpointKey(fields[1], result.Port, result.Protocol)
+                if cp.done[key] {
+                    // Reported again by a later run: keep the latest result.
+                    cp.restored[fields[1]] = withoutPort(cp.restored[fields[1]], result.Port, result.Protocol)
+                }
+                cp.done[key] = true
+                cp.restored[fields[1]] = append(cp.restored[fields[1]], result)
+            default:
+                return nil, fmt.Errorf("%s:%d: malformed line", path, n+1)
+            }
+        }
+    } else if !os.IsNotExist(err) {
+        return nil, err
+    }
+    file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+    if err != nil {
+        return nil, err
+    }
+    cp.file = file
+    go func() {
+        defer close(cp.stopped)
+        ticker := time.NewTicker(time.Second)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-cp.stop:
+                cp.flush()
+                return
+            case <-ticker.C:
+                cp.flush()
+            }
+        }
+    }()
+    return cp, nil
+}
+
+// withoutPort returns ports minus any result for port/protocol.
+func withoutPort(ports []PortResult, port int, protocol string) []PortResult {
+    kept := ports[:0]
+    for _, p := range ports {
+        if p.Port != port || p.Protocol != protocol {
+            kept = append(kept, p)
+        }
+    }
+    return kept
+}
+
+// completed reports whether a previous run already finished this probe.
+func (cp *checkpoint) completed(host string, port int, protocol string) bool {
+    return cp.done[checkpointKey(host, port, protocol)]
+}
+
+// finish marks a probe as done, saving its result first when there is one to
+// report.
+func (cp *checkpoint) finish(host string, port int, protocol string, result *PortResult) {
+    cp.mu.Lock()
+    defer cp.mu.Unlock()
+    if result != nil {
+        line, err := json.Marshal(result)
+        if err == nil {
+            fmt.Fprintf(cp.file, "port %s %s\n", host, line)
+        }
+    }
+    if cp.pending[host] == nil {
+        cp.pending[host] = map[string][]int{}
+    }
+    cp.pending[host][protocol] = append(cp.pending[host][protocol], port)
+}
+
+func (cp *checkpoint) flush() {
+    cp.mu.Lock()
+    defer cp.mu.Unlock()
+    for host, protocols := range cp.pending {
+        for protocol, ports := range protocols {
+            fmt.Fprintf(cp.file, "done %s %s %s\n", host, protocol, formatPortRanges(ports))
+        }
+    }
+    cp.pending = map[string]map[string][]int{}
+}
+
+// Close writes out the remaining finished probes.
+func (cp *checkpoint) Close() error {
+    close(cp.stop)
+    <-cp.stopped
+    return cp.file.Close()
+}
+
+// resultCache keeps probe results on disk for -cache, so overlapping scans
+// run in quick succession can reuse them. The file holds one JSON entry per
+// line; later lines win, and entries older than the TTL are dropped when it
+// is loaded and when it is written back.
+//
+// An open TCP port is only answered from the cache when the entry has every
+// enrichment (banner, TLS, ...) the scan asks for. Once the cache holds
+// maxCacheEntries, closed and filtered results are no longer added, so a
+// wide sweep does not keep one entry per probe; reported ports always are.
+type resultCache struct {
+    mu      sync.Mutex
+    path    string
+    ttl     time.Duration
+    entries map[string]cacheEntry
+    now     func() time.Time
+}
+
+const maxCacheEntries = 100000
+
+type cacheEntry struct {
+    Host   string     `json:"host"`
+    Time   time.Time  `json:"time"`
+    Result PortResult `json:"result"`
+    // Enrichments lists what was collected for an open port, as returned
+    // by Config.enrichments.
+    Enrichments []string `json:"enrichments,omitempty"`
+}
+
+func defaultCachePath() string {
+    dir, err := os.UserCacheDir()
+    if err != nil {
+        dir = os.TempDir()
+    }
+    return filepath.Join(dir, "hunting-rabbit", "scan-cache.ndjson")
+}
+
+// openResultCache loads the fresh entries of path, which need not exist.
+func openResultCache(path string, ttl time.Duration) (*resultCache, error) {
+    c := &resultCache{path: path, ttl: ttl, entries: map[string]cacheEntry{}, now: time.Now}
+    data, err := os.ReadFile(path)
+    if os.IsNotExist(err) {
+        return c, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    for n, line := range strings.Split(string(data), "\n") {
+        if line == "" {
+            continue
+        }
+        var entry cacheEntry
+        if err := json.Unmarshal([]byte(line), &entry); err != nil {
+            return nil, fmt.Errorf("%s:%d: %v", path, n+1, err)
+        }
+        if c.fresh(entry) {
+            c.add(entry)
+        }
+    }
+    return c, nil
+}
+
+func (c *resultCache) fresh(entry cacheEntry) bool {
+    return c.now().Sub(entry.Time) < c.ttl
+}
+
+// lookup returns the cached result of a probe if it is recent enough and,
+// for an open TCP port, was collected with every enrichment in want.
+func (c *resultCache) lookup(host string, port int, protocol string, want []string) (PortResult, bool) {
+    if c == nil {
+        return PortResult{}, false
+    }
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    entry, ok := c.entries[checkpointKey(host, port, protocol)]
+    if !ok || !c.fresh(entry) {
+        return PortResult{}, false
+    }
+    if entry.Result.State == "open" && protocol == "tcp" {
+        have := map[string]bool{}
+        for _, name := range entry.Enrichments {
+            have[name] = true
+        }
+        for _, name := range want {
+            if !have[name] {
+                return PortResult{}, false
+            }
+        }
+    }
+    return entry.Result, true
+}
+
+// store records a probe's result along with the enrichments collected for
+// it.
+func (c *resultCache) store(host string, result PortResult, enrichments []string) {
+    if c == nil {
+        return
+    }
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.add(cacheEntry{Host: host, Time: c.now(), Result: result, Enrichments: enrichments})
+}
+
+// add keeps entry unless it is a closed or filtered result that would take
+// the cache past maxCacheEntries. c.mu must be held once c is shared.
+func (c *resultCache) add(entry cacheEntry) {
+    key := checkpointKey(entry.Host, entry.Result.Port, entry.Result.Protocol)
+    if _, ok := c.entries[key]; !ok && len(c.entries) >= maxCacheEntries {
+        if state := entry.Result.State; state == "closed" || state == "filtered" {
+            return
+        }
+    }
+    c.entries[key] = entry
+}
+
+// Len is the number of entries still fresh.
+func (c *resultCache) Len() int {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    n := 0
+    for _, entry := range c.entries {
+        if c.fresh(entry) {
+            n++
+        }
+    }
+    return n
+}
+
+// Close writes the fresh entries back, replacing the file atomically.
+func (c *resultCache) Close() error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+        return err
+    }
+    tmp, err := os.CreateTemp(filepath.Dir(c.path), ".scan-cache-*")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(tmp.Name())
+    w := bufio.NewWriter(tmp)
+    for _, entry := range c.entries {
+        if !c.fresh(entry) {
+            continue
+        }
+        line, err := json.Marshal(entry)
+        if err != nil {
+            continue
+        }
+        w.Write(line)
+        w.WriteByte('\n')
+    }
+    if err := w.Flush(); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Close(); err != nil {
+        return err
+    }
+    return os.Rename(tmp.Name(), c.path)
+}
+
+// findingsLog appends each finished host with findings to an NDJSON file as
+// soon as it completes, syncing to disk at most every few seconds, so a
+// crash loses at most that much.
+type findingsLog struct {
+    mu       sync.Mutex
+    file     *os.File
+    lastSync time.Time
+}
+
+const findingsLogSyncInterval = 5 * time.Second
+
+func openFindingsLog(path string) (*findingsLog, error) {
+    file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+    if err != nil {
+        return nil, err
+    }
+    return &findingsLog{file: file, lastSync: time.Now()}, nil
+}
+
+func (l *findingsLog) write(result HostResult) error {
+    line, err := json.Marshal(result)
+    if err != nil {
+        return err
+    }
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    if _, err := l.file.Write(append(line, '\n')); err != nil {
+        return err
+    }
+    if time.Since(l.lastSync) >= findingsLogSyncInterval {
+        l.lastSync = time.Now()
+        return l.file.Sync()
+    }
+    return nil
+}
+
+func (l *findingsLog) Close() error {
+    l.file.Sync()
+    return l.file.Close()
+}
+
+// eventStream writes progress events as NDJSON for a parent process to
+// follow, separate from the results and the console output.
+type eventStream struct {
+    mu   sync.Mutex
+    file *os.File
+}
+
+// scanEvent is one line of the event stream. Event is "scan_start",
+// "host_complete" or "scan_complete".
+type scanEvent struct {
+    Event string `json:"event"`
+    Host  string `json:"host,omitempty"`
+    Open  int    `json:"open"`
+    Done  int    `json:"done"`
+    Total int    `json:"total"`
+}
+
+// openEventStream opens path for writing; on Linux /dev/fd/N writes to a
+// descriptor inherited from the parent.
+func openEventStream(path string) (*eventStream, error) {
+    file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+    if err != nil {
+        return nil, err
+    }
+    return &eventStream{file: file}, nil
+}
+
+func (e *eventStream) emit(event scanEvent) {
+    line, err := json.Marshal(event)
+    if err != nil {
+        return
+    }
+    e.mu.Lock()
+    defer e.mu.Unlock()
+    if _, err := e.file.Write(append(line, '\n')); err != nil {
+        fmt.Printf("[!] Writing events: %v\n", err)
+    }
+}
+
+func (e *eventStream) Close() error {
+    return e.file.Close()
+}
+
+// formatPortRanges is the inverse of parsePorts for an explicit port list.
+func formatPortRanges(ports []int) string {
+    sorted := append([]int(nil), ports...)
+    sort.Ints(sorted)
+    var parts []string
+    for i := 0; i < len(sorted); {
+        j := i
+        for j+1 < len(sorted) && sorted[j+1] <= sorted[j]+1 {
+            j++
+        }
+        if sorted[i] == sorted[j] {
+            parts = append(parts, strconv.Itoa(sorted[i]))
+        } else {
+            parts = append(parts, fmt.Sprintf("%d-%d", sorted[i], sorted[j]))
+        }
+        i = j + 1
+    }
+    return strings.Join(parts, ",")
+}
+
+func parseProtocols(protoList string) ([]string, error) {
+    protocols := []string{}
+    seen := make(map[string]bool)
+    for _, item := range strings.Split(protoList, ",") {
+        protocol := strings.ToLower(strings.TrimSpace(item))
+        if protocol != "tcp" && protocol != "udp" {
+            return nil, fmt.Errorf("unsupported protocol %q", item)
+        }
+        if !seen[protocol] {
+            seen[protocol] = true
+            protocols = append(protocols, protocol)
+        }
+    }
+    return protocols, nil
+}
+
+// groupPort is one port of a named group, with the protocols it is
+// normally spoken over.
+type groupPort struct {
+    port      int
+    protocols string
+    name      string
+}
+
+// portGroups are the named port lists accepted by -p. Every port is still
+// probed with every -proto protocol; the protocols are only used to point
+// out ports the chosen protocols would miss.
+var portGroups = map[string][]groupPort{
+    // OT/ICS protocols.
+    "ot": {
+        {102, "tcp", "Siemens S7"},
+        {502, "tcp", "Modbus/TCP"},
+        {20000, "tcp,udp", "DNP3"},
+        {44818, "tcp,udp", "EtherNet/IP"},
+        {47808, "udp", "BACnet/IP"},
+    },
+}
+
+// groupProtocolNotes lists the ports of named groups in portRange that are
+// normally spoken over none of the scan's protocols.
+func groupProtocolNotes(portRange string, protocols []string) []string {
+    notes := []string{}
+    for _, item := range strings.Split(portRange, ",") {
+        group := strings.ToLower(strings.TrimSpace(item))
+        for _, gp := range portGroups[group] {
+            covered := false
+            for _, protocol := range protocols {
+                if strings.Contains(gp.protocols, protocol) {
+                    covered = true
+                }
+            }
+            if !covered {
+                notes = append(notes, fmt.Sprintf("%s: %d (%s) is usually %s, add it to -proto to cover it", group, gp.port, gp.name, gp.protocols))
+            }
+        }
+    }
+    return notes
+}
+
+// defaultPorts is the built-in list scanned with "-p default", or on TCP
+// when -p is empty and -require-ports is not set.
+var defaultPorts = []int{21,22,23,25,53,80,81,88,89,110,113,119,123,135,139,143,161,179,199,389,427,443,445,465,513,514,
+    515,543,544,548,554,587,631,646,873,902,990,993,995,1080,1433,1521,1701,1720,1723,1755,1900,2000,2049,
+    2121,2181,2375,2376,3128,3306,3389,3500,3541,3689,4000,4040,4063,4333,4369,4443,4488,4500,4567,4899,
+    5000,5001,5004,5006,5007,5008,5009,5060,5104,5222,5223,5269,5351,5353,5432,5555,5601,5632,5800,5801,
+    5900,5901,5938,5984,5999,6000,6001,6379,6443,6588,6665,6666,6667,6668,6669,7001,7002,7077,7443,7574,
+    8000,8001,8008,8010,8080,8081,8082,8086,8088,8090,8091,8181,8443,8484,8600,8649,8686,8787,8888,9000,
+    9001,9002,9003,9009,9042,9050,9071,9080,9090,9091,9200,9300,9418,9443,9600,9800,9871,9999,10000,11211,
+    12345,15672,16010,16080,16384,27017,27018,50050}
+
+// defaultUDPPorts is scanned on UDP when no ports are given: the common UDP
+// services, including those with a probe in udpProbes.
+var defaultUDPPorts = []int{7,9,17,19,53,67,68,69,111,123,135,137,138,139,161,162,389,427,500,514,520,
+    623,631,1194,1434,1701,1812,1813,1900,2049,3478,3702,4500,5060,5353,5355,11211,47808}
+
+// protocolPorts holds the ports to probe for each protocol.
+type protocolPorts map[string][]int
+
+// samePorts probes ports on every one of protocols.
+func samePorts(ports []int, protocols []string) protocolPorts {
+    p := protocolPorts{}
+    for _, protocol := range protocols {
+        p[protocol] = ports
+    }
+    return p
+}
+
+// specPorts is what a -p spec that parsePorts expanded to ports means per
+// protocol. Without a spec each protocol gets its own defaults, as the TCP
+// ones are mostly useless for UDP.
+func specPorts(portRange string, ports []int, protocols []string) protocolPorts {
+    p := samePorts(ports, protocols)
+    if portRange == "" && p["udp"] != nil {
+        p["udp"] = defaultUDPPorts
+    }
+    return p
+}
+
+// count is the number of port/protocol pairs to probe.
+func (p protocolPorts) count() int {
+    n := 0
+    for _, ports := range p {
+        n += len(ports)
+    }
+    return n
+}
+
+// parsePorts expands a port list such as "22,80,8000-8100", which may also
+// name "default" or a group from portGroups such as "ot". An empty list is
+// the default ports. Ports are sorted and duplicates dropped unless
+// preserveOrder is set, in which case the list is kept exactly as written,
+// repeats included.
+func parsePorts(portRange string, preserveOrder bool) ([]int, error) {
+    ports := []int{}
+    if portRange == "" {
+        ports = append(ports, defaultPorts...)
+    } else {
+        for _, item := range strings.Split(portRange, ",") {
+            // Lists pasted as "80, 443, 8000 - 8100" are common.
+            item = strings.TrimSpace(item)
+            if strings.EqualFold(item, "default") {
+                ports = append(ports, defaultPorts...)
+            } else if group, ok := portGroups[strings.ToLower(item)]; ok {
+                for _, gp := range group {
+                    ports = append(ports, gp.port)
+                }
+            } else if strings.Contains(item, "-") {
+                rangeParts := strings.SplitN(item, "-", 2)
+                startPort, err := parsePort(rangeParts[0])
+                if err != nil {
+                    return nil, err
+                }
+                endPort, err := parsePort(rangeParts[1])
+                if err != nil {
+                    return nil, err
+                }
+                if startPort > endPort {
+                    return nil, fmt.Errorf("invalid port range %q", item)
+                }
+                for i := startPort; i <= endPort; i++ {
+                    ports = append(ports, i)
+                }
+            } else {
+                port, err := parsePort(item)
+                if err != nil {
+                    return nil, err
+                }
+                ports = append(ports, port)
+            }
+        }
+    }
+    if preserveOrder {
+        return ports, nil
+    }
+    sort.Ints(ports)
+    unique := ports[:0]
+    for i, port := range ports {
+        if i == 0 || port != ports[i-1] {
+            unique = append(unique, port)
+        }
+    }
+    return unique, nil
+}
+
+func parsePort(s string) (int, error) {
+    port, err := strconv.Atoi(strings.TrimSpace(s))
+    if err != nil || port < 1 || port > 65535 {
+        return 0, fmt.Errorf("invalid port %q", s)
+    }
+    return port, nil
+}
+
+// scanFlat probes every (host, port) pair of the scan from the single pool
+// in cfg.pool instead of giving each host worker its own probes, so a few
+// slow hosts cannot leave most of the workers idle. Each host's results
+// are collected by its own goroutine and handed to finish once its last
+// probe is done.
+func scanFlat(hosts []string, portsFor func(string) protocolPorts, cfg Config, finish func(HostResult)) {
+    for _, host := range hosts {
+        // Hosts not started before an abort are not scanned at all.
+        if cfg.abort.ctx.Err() != nil {
+            finish(HostResult{Host: host})
+            continue
+        }
+        h := newHostScan(host, portsFor(host), cfg)
+        go func() {
+            defer h.cancel()
+            finish(h.collect())
+        }()
+        for _, protocol := range cfg.Protocols {
+            for _, port := range h.ports[protocol] {
+                if !h.pending(port, protocol) || h.cancelled(port, protocol) {
+                    continue
+                }
+                port, protocol := port, protocol
+                h.wg.Add(1)
+                cfg.pool.run(h.ctx, func() { h.probe(port, protocol) })
+            }
+        }
+        go func() {
+            h.wg.Wait()
+            close(h.results)
+        }()
+    }
+}
+
+// ScanNetwork scans cfg.Ports on every host of cfg.Targets and returns the
+// hosts with something to report. An error means the scan did not finish: a
+// target could not be expanded, the scan was aborted, or ctx was cancelled
+// or ran out, in which case the error is ctx.Err(). Unfinished scans skip
+// the hosts not yet started, end the probes in flight as not scanned and
+// still return the hosts finished so far.
+func ScanNetwork(ctx context.Context, cfg Config) ([]HostResult, Stats, error) {
+    var results []HostResult
+    targets, portRange := cfg.Targets, cfg.Ports
+    // Left unset, these would scan nothing or need raw sockets.
+    if len(cfg.Protocols) == 0 {
+        cfg.Protocols = []string{"tcp"}
+    }
+    if cfg.ScanType == "" {
+        cfg.ScanType = "connect"
+    }
+    if cfg.MaxWorkers < 1 {
+        cfg.MaxWorkers = 1
+    }
+    stats := Stats{}
+    start := time.Now()
+    // A probe pool left without workers would never run a probe.
+    if cfg.MaxGoroutines > 0 && cfg.Flat && flatPoolSize(cfg.MaxGoroutines) < 1 {
+        return nil, stats.finish(start), fmt.Errorf("MaxGoroutines must be at least %d with Flat", goroutineReserve+3)
+    }
+    if cfg.MaxGoroutines > 0 && !cfg.Flat && probePoolSize(cfg.MaxGoroutines, cfg.MaxWorkers) < 1 {
+        return nil, stats.finish(start), fmt.Errorf("MaxGoroutines must be at least %d with %d MaxWorkers", 2*cfg.MaxWorkers+goroutineReserve+1, cfg.MaxWorkers)
+    }
+    cfg.rng = newLockedRand(cfg.Seed)
+    cfg.abort = newScanAbort(ctx)
+    defer cfg.abort.cancel()
+    cfg.retries = newRetryBudget(cfg.MaxRetriesTotal)
+    cfg.probeErrors = newProbeErrors()
+    // Through a proxy the answer times are the proxy's.
+    if cfg.Proxy == nil && cfg.Timeout > 0 {
+        cfg.rtt = newTimeoutCheck(cfg.Timeout)
+    }
+    if cfg.Names {
+        cfg.hostnames = newHostnameCache()
+    }
+    hosts := []string{}
+    // Loopback hosts asked for as "localhost" are clearly intended and get
+    // no special-use warning.
+    intended := map[string]bool{}
+    // hostPortSpecs holds the -iL port lists of hosts that have one; a host
+    // named by several such lines gets all of them.
+    hostPortSpecs := map[string]string{}
+    for _, target := range targets {
+        if network, ok := hostBitsSet(target); ok && !cfg.FromHost {
+            fmt.Printf("[*] %s has host bits set, scanning the whole network %s (use -from-host to start at the given address)\n", target, network)
+        }
+        targetHosts, err := hostsInNetwork(target, cfg.FromHost)
+        if err != nil {
+            return nil, stats.finish(start), fmt.Errorf("%s: %v", target, err)
+        }
+        if isLocalhost(target) {
+            for _, host := range targetHosts {
+                intended[host] = true
+            }
+        }
+        if spec, ok := cfg.TargetPorts[target]; ok {
+            for _, host := range targetHosts {
+                if hostPortSpecs[host] != "" {
+                    spec = hostPortSpecs[host] + "," + spec
+                }
+                hostPortSpecs[host] = spec
+            }
+        }
+        hosts = append(hosts, targetHosts...)
+    }
+    hosts, duplicates := dedupeHosts(hosts)
+    if duplicates > 0 && cfg.Verbose {
+        fmt.Printf("[*] Skipped %d duplicate host(s) from overlapping targets\n", duplicates)
+    }
+    // With a proxy, names are left for the proxy to resolve.
+    if cfg.Proxy == nil {
+        hosts, cfg.resolved = resolveHosts(ctx, hosts, cfg)
+    }
+    hosts = checkSpecialUse(hosts, intended, cfg)
+    if cfg.Sample > 0 {
+        total := len(hosts)
+        hosts = sampleHosts(hosts, cfg.Sample, cfg.rng)
+        fmt.Printf("[*] Sampling %d of %d host(s) (seed %d)\n", len(hosts), total, cfg.Seed)
+    }
+    if cfg.ScanType != "connect" {
+        raw, err := newRawScanner(cfg.Resolver, cfg.Decoys)
+        if err != nil {
+            fmt.Printf("[!] %s scan needs raw socket privileges (root or CAP_NET_RAW), falling back to connect scan: %v\n", cfg.ScanType, err)
+            cfg.ScanType = "connect"
+        } else {
+            defer raw.Close()
+            cfg.raw = raw
+            // The raw scanner only builds IPv4 packets; other targets, and
+            // names left for it to resolve behind a proxy, would all come
+            // back filtered.
+            skipped := []string{}
+            for _, host := range hosts {
+                if ip := net.ParseIP(cfg.address(host)); (ip == nil || ip.To4() == nil) && !cfg.rawSkipped[host] {
+                    if cfg.rawSkipped == nil {
+                        cfg.rawSkipped = make(map[string]bool)
+                    }
+                    cfg.rawSkipped[host] = true
+                    skipped = append(skipped, host)
+                }
+            }
+            if len(skipped) > 0 {
+                fmt.Printf("[!] %s scan only supports IPv4 targets, falling back to connect scan for %d target(s): %s\n", cfg.ScanType, len(skipped), strings.Join(skipped, ", "))
+            }
+        }
+    }
+    if cfg.Favicon || cfg.HTTP {
+        cfg.httpClient = newHTTPClient(cfg)
+    }
+    if cfg.Adaptive {
+        cfg.probeBudget = make(chan struct{}, cfg.MaxWorkers*adaptiveStartWindow)
+    }
+    switch {
+    case cfg.Flat:
+        size := cfg.MaxWorkers
+        if cfg.MaxGoroutines > 0 {
+            size = flatPoolSize(cfg.MaxGoroutines)
+        }
+        cfg.pool = newProbePool(size)
+        defer cfg.pool.close()
+    case cfg.MaxGoroutines > 0:
+        cfg.pool = newProbePool(probePoolSize(cfg.MaxGoroutines, cfg.MaxWorkers))
+        defer cfg.pool.close()
+    }
+    ch := make(chan string, cfg.MaxWorkers)
+    workerResultsCh := make(chan *HostResult, len(hosts))
+    ports, err := parsePorts(portRange, cfg.PreserveOrder)
+    if err != nil {
+        return nil, stats.finish(start), err
+    }
+    scanPorts := specPorts(portRange, ports, cfg.Protocols)
+    hostPorts := map[string]protocolPorts{}
+    for host, spec := range hostPortSpecs {
+        portList, err := parsePorts(spec, cfg.PreserveOrder)
+        if err != nil {
+            return nil, stats.finish(start), fmt.Errorf("%s: %v", host, err)
+        }
+        hostPorts[host] = samePorts(portList, cfg.Protocols)
+    }
+    portsFor := func(host string) protocolPorts {
+        if portList, ok := hostPorts[host]; ok {
+            return portList
+        }
+        return scanPorts
+    }
+    finishHost := func(result HostResult) {
+        if cfg.Names && len(result.Ports) > 0 && net.ParseIP(result.Host) != nil {
+            result.Hostname = lookupHostname(result.Host, cfg)
+        }
+        if cfg.OnHostComplete != nil {
+            cfg.OnHostComplete(result)
+        }
+        workerResultsCh <- &result
+    }
+    if !cfg.Flat {
+        for i := 0; i < cfg.MaxWorkers; i++ {
+            go func() {
+                for host := range ch {
+                    result := HostResult{Host: host}
+                    // Hosts not started before an abort are not scanned at all.
+                    if cfg.abort.ctx.Err() == nil {
+                        result = scanHost(host, portsFor(host), cfg)
+                    }
+                    finishHost(result)
+                }
+            }()
+        }
+    }
+    if cfg.Events != nil {
+        cfg.Events.emit(scanEvent{Event: "scan_start", Total: len(hosts)})
+    }
+    if cfg.Flat {
+        go scanFlat(hosts, portsFor, cfg, finishHost)
+    } else {
+        for _, host := range hosts {
+            ch <- host
+        }
+        close(ch)
+    }
+    for i := 0; i < len(hosts); i++ {
+        result := <-workerResultsCh
+        stats.add(*result)
+        if cfg.Slowest > 0 {
+            stats.noteTime(*result, cfg.Slowest)
+        }
+        if cfg.Events != nil {
+            cfg.Events.emit(scanEvent{Event: "host_complete", Host: result.Host, Open: result.Open, Done: i + 1, Total: len(hosts)})
+        }
+        if len(result.Ports) == 0 {
+            result = nil
+        }
+        if result != nil {
+            results = append(results, *result)
+            if cfg.FindingsLog != nil {
+                if err := cfg.FindingsLog.write(*result); err != nil {
+                    fmt.Printf("[!] Writing findings log: %v\n", err)
+                }
+            }
+        }
+        if cfg.progress != nil {
+            cfg.progress(i+1, len(hosts), result)
+        }
+    }
+    cfg.rtt.check()
+    stats.ProbeErrors = cfg.probeErrors.snapshot()
+    if cfg.Events != nil {
+        cfg.Events.emit(scanEvent{Event: "scan_complete", Open: stats.OpenPorts, Done: len(hosts), Total: len(hosts)})
+    }
+    if cfg.ARP {
+        addMACAddresses(results)
+    }
+    if cfg.IdentifyProcess && !addProcesses(results) {
+        fmt.Println("[!] -identify-process needs /proc/net (Linux), skipping it")
+    }
+    if cfg.GeoIP != nil {
+        for i := range results {
+            results[i].Geo = cfg.GeoIP.lookupGeo(results[i].Host)
+        }
+    }
+    if reason := cfg.abort.stopped(); reason != "" {
+        return results, stats.finish(start), fmt.Errorf("scan aborted: %s", reason)
+    }
+    if err := ctx.Err(); err != nil {
+        return results, stats.finish(start), err
+    }
+    return results, stats.finish(start), nil
+}
+
+// mmdbReader reads MaxMind DB files (GeoLite2/GeoIP2 City, Country and ASN)
+// as described in the MaxMind DB format specification 2.0.
+type mmdbReader struct {
+    data       []byte
+    nodeCount  uint64
+    recordSize uint64
+    ipVersion  uint64
+    dataStart  uint64
+}
+
+var mmdbMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+func openMMDB(path string) (*mmdbReader, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    start := strings.LastIndex(string(data), string(mmdbMetadataMarker))
+    if start < 0 {
+        return nil, fmt.Errorf("%s: not a MaxMind DB file", path)
+    }
+    r := &mmdbReader{data: data}
+    metaStart := uint64(start + len(mmdbMetadataMarker))
+    meta, _, err := r.decode(metaStart, metaStart)
+    metadata, ok := meta.(map[string]interface{})
+    if err != nil || !ok {
+        return nil, fmt.Errorf("%s: invalid metadata", path)
+    }
+    r.nodeCount, _ = metadata["node_count"].(uint64)
+    r.recordSize, _ = metadata["record_size"].(uint64)
+    r.ipVersion, _ = metadata["ip_version"].(uint64)
+    if r.recordSize != 24 && r.recordSize != 28 && r.recordSize != 32 {
+        return nil, fmt.Errorf("%s: unsupported record size %d", path, r.recordSize)
+    }
+    // Every node takes at least 6 bytes, so a larger count can only be
+    // corrupt and would overflow the tree size.
+    if r.nodeCount > uint64(start)/6 {
+        return nil, fmt.Errorf("%s: search tree larger than file", path)
+    }
+    r.dataStart = r.nodeCount*r.recordSize/4 + 16
+    if r.dataStart > uint64(start) {
+        return nil, fmt.Errorf("%s: search tree larger than file", path)
+    }
+    return r, nil
+}
+
+func (r *mmdbReader) record(node uint64, bit uint) uint64 {
+    switch r.recordSize {
+    case 24:
+        off := node*6 + uint64(bit)*3
+        return uint64(r.data[off])<<16 | uint64(r.data[off+1])<<8 | uint64(r.data[off+2])
+    case 28:
+        off := node * 7
+        if bit == 0 {
+            return uint64(r.data[off+3]>>4)<<24 | uint64(r.data[off])<<16 | uint64(r.data[off+1])<<8 | uint64(r.data[off+2])
+        }
+        return uint64(r.data[off+3]&0x0f)<<24 | uint64(r.data[off+4])<<16 | uint64(r.data[off+5])<<8 | uint64(r.data[off+6])
+    default:
+        off := node*8 + uint64(bit)*4
+        return uint64(binary.BigEndian.Uint32(r.data[off:]))
+    }
+}
+
+// lookup walks the search tree for ip and decodes its data record.
+func (r *mmdbReader) lookup(ip net.IP) (interface{}, bool) {
+    // IPv4 addresses live under ::/96 in IPv6 databases.
+    bits := ip.To16()
+    if ip4 := ip.To4(); ip4 != nil {
+        bits = ip4
+        if r.ipVersion == 6 {
+            bits = append(make([]byte, 12), ip4...)
+        }
+    } else if r.ipVersion == 4 {
+        return nil, false
+    }
+    node := uint64(0)
+    for i := 0; i < len(bits)*8 && node < r.nodeCount; i++ {
+        bit := uint(bits[i/8]>>(7-uint(i%8))) & 1
+        node = r.record(node, bit)
+    }
+    // Records between the node count and the data section's 16-byte
+    // separator point nowhere.
+    if node < r.nodeCount+16 {
+        return nil, false
+    }
+    offset := node - r.nodeCount - 16 + r.dataStart
+    value, _, err := r.decode(offset, r.dataStart)
+    return value, err == nil
+}
+
+// mmdbMaxDepth caps how deeply maps, arrays and pointers may nest, so a
+// corrupt file with a pointer cycle fails instead of recursing forever.
+const mmdbMaxDepth = 64
+
+var errMMDBRange = errors.New("mmdb: field out of range")
+
+// bytes returns the n bytes at offset, or errMMDBRange if the file ends
+// before them.
+func (r *mmdbReader) bytes(offset, n uint64) ([]byte, error) {
+    if offset > uint64(len(r.data)) || n > uint64(len(r.data))-offset {
+        return nil, errMMDBRange
+    }
+    return r.data[offset : offset+n], nil
+}
+
+// decode reads one data field at offset; pointers are relative to base.
+func (r *mmdbReader) decode(offset, base uint64) (interface{}, uint64, error) {
+    return r.decodeDepth(offset, base, 0)
+}
+
+func (r *mmdbReader) decodeDepth(offset, base uint64, depth int) (interface{}, uint64, error) {
+    if depth > mmdbMaxDepth {
+        return nil, 0, errors.New("mmdb: data nested too deeply")
+    }
+    b, err := r.bytes(offset, 1)
+    if err != nil {
+        return nil, 0, err
+    }
+    ctrl := b[0]
+    offset++
+    kind := ctrl >> 5
+    if kind == 1 {
+        size := uint64((ctrl >> 3) & 0x3)
+        value := uint64(ctrl & 0x7)
+        b, err := r.bytes(offset, size+1)
+        if err != nil {
+            return nil, 0, err
+        }
+        var pointer uint64
+        switch size {
+        case 0:
+            pointer = value<<8 | uint64(b[0])
+        case 1:
+            pointer = (value<<16 | uint64(b[0])<<8 | uint64(b[1])) + 2048
+        case 2:
+            pointer = (value<<24 | uint64(b[0])<<16 | uint64(b[1])<<8 | uint64(b[2])) + 526336
+        default:
+            pointer = uint64(binary.BigEndian.Uint32(b))
+        }
+        decoded, _, err := r.decodeDepth(base+pointer, base, depth+1)
+        return decoded, offset + size + 1, err
+    }
+    if kind == 0 {
+        b, err := r.bytes(offset, 1)
+        if err != nil {
+            return nil, 0, err
+        }
+        kind = 7 + b[0]
+        offset++
+    }
+    size := uint64(ctrl & 0x1f)
+    if size >= 29 {
+        n := size - 28
+        b, err := r.bytes(offset, n)
+        if err != nil {
+            return nil, 0, err
+        }
+        switch size {
+        case 29:
+            size = 29 + uint64(b[0])
+        case 30:
+            size = 285 + uint64(binary.BigEndian.Uint16(b))
+        case 31:
+            size = 65821 + (uint64(b[0])<<16 | uint64(b[1])<<8 | uint64(b[2]))
+        }
+        offset += n
+    }
+    switch kind {
+    case 7, 11:
+        // Every entry takes at least a byte, which also bounds the
+        // allocation below.
+        if size > uint64(len(r.data))-offset {
+            return nil, 0, errMMDBRange
+        }
+    case 14:
+    default:
+        if _, err := r.bytes(offset, size); err != nil {
+            return nil, 0, err
+        }
+    }
+    switch kind {
+    case 2, 4:
+        return string(r.data[offset : offset+size]), offset + size, nil
+    case 3:
+        if size != 8 {
+            return nil, 0, fmt.Errorf("mmdb: double of %d bytes", size)
+        }
+        return math.Float64frombits(binary.BigEndian.Uint64(r.data[offset:])), offset + size, nil
+    case 15:
+        if size != 4 {
+            return nil, 0, fmt.Errorf("mmdb: float of %d bytes", size)
+        }
+        return float64(math.Float32frombits(binary.BigEndian.Uint32(r.data[offset:]))), offset + size, nil
+    case 5, 6, 8, 9, 10:
+        var value uint64
+        for _, b := range r.data[offset : offset+size] {
+            value = value<<8 | uint64(b)
+        }
+        return value, offset + size, nil
+    case 14:
+        return size != 0, offset, nil
+    case 7:
+        m := make(map[string]interface{}, size)
+        for i := uint64(0); i < size; i++ {
+            key, next, err := r.decodeDepth(offset, base, depth+1)
+            if err != nil {
+                return nil, 0, err
+            }
+            value, next, err := r.decodeDepth(next, base, depth+1)
+            if err != nil {
+                return nil, 0, err
+            }
+            keyString, _ := key.(string)
+            m[keyString] = value
+            offset = next
+        }
+        return m, offset, nil
+    case 11:
+        array := make([]interface{}, 0, size)
+        for i := uint64(0); i < size; i++ {
+            value, next, err := r.decodeDepth(offset, base, depth+1)
+            if err != nil {
+                return nil, 0, err
+            }
+            array = append(array, value)
+            offset = next
+        }
+        return array, offset, nil
+    }
+    return nil, 0, fmt.Errorf("mmdb: unsupported data type %d", kind)
+}
+
+// lookupGeo returns the country, city and ASN recorded for a public IP, or
+// nil for private, loopback and link-local addresses.
+func (r *mmdbReader) lookupGeo(host string) *GeoInfo {
+    ip := net.ParseIP(host)
+    if ip == nil || ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
+        return nil
+    }
+    value, ok := r.lookup(ip)
+    record, _ := value.(map[string]interface{})
+    if !ok || record == nil {
+        return nil
+    }
+    field := func(path ...string) interface{} {
+        var current interface{} = record
+        for _, key := range path {
+            m, ok := current.(map[string]interface{})
+            if !ok {
+                return nil
+            }
+            current = m[key]
+        }
+        return current
+    }
+    geo := &GeoInfo{}
+    geo.Country, _ = field("country", "iso_code").(string)
+    geo.City, _ = field("city", "names", "en").(string)
+    geo.ASN, _ = field("autonomous_system_number").(uint64)
+    geo.Org, _ = field("autonomous_system_organization").(string)
+    if *geo == (GeoInfo{}) {
+        return nil
+    }
+    return geo
+}
+
+// ouiVendors maps the first three bytes of a MAC address to its vendor. It
+// is a curated subset of the IEEE registry covering common virtualisation,
+// network and embedded hardware.
+var ouiVendors = map[string]string{
+    "00:05:69": "VMware", "00:0c:29": "VMware", "00:1c:14": "VMware", "00:50:56": "VMware",
+    "08:00:27": "Oracle VirtualBox", "0a:00:27": "Oracle VirtualBox",
+    "52:54:00": "QEMU/KVM", "00:16:3e": "Xen", "00:15:5d": "Microsoft Hyper-V",
+    "02:42:ac": "Docker", "00:1c:42": "Parallels",
+    "b8:27:eb": "Raspberry Pi", "dc:a6:32": "Raspberry Pi", "e4:5f:01": "Raspberry Pi", "d8:3a:dd": "Raspberry Pi",
+    "00:00:0c": "Cisco", "00:1b:54": "Cisco", "00:25:45": "Cisco", "58:97:bd": "Cisco",
+    "00:1b:21": "Intel", "3c:fd:fe": "Intel", "a0:36:9f": "Intel", "f8:f2:1e": "Intel",
+    "00:14:22": "Dell", "18:66:da": "Dell", "f8:bc:12": "Dell",
+    "00:17:a4": "HP", "3c:d9:2b": "HP", "9c:8e:99": "HP", "ec:b1:d7": "HP",
+    "00:03:93": "Apple", "3c:22:fb": "Apple", "a4:83:e7": "Apple", "f0:18:98": "Apple",
+    "00:11:32": "Synology", "00:08:9b": "QNAP", "24:5e:be": "QNAP",
+    "00:27:22": "Ubiquiti", "24:a4:3c": "Ubiquiti", "78:8a:20": "Ubiquiti", "f4:92:bf": "Ubiquiti",
+    "50:c7:bf": "TP-Link", "14:cc:20": "TP-Link", "c0:4a:00": "TP-Link",
+    "00:09:0f": "Fortinet", "00:1b:17": "Palo Alto Networks", "00:0d:b9": "PC Engines",
+    "00:1d:0f": "TP-Link", "00:e0:4c": "Realtek", "00:0e:c6": "ASIX",
+    "ac:1f:6b": "Supermicro", "00:25:90": "Supermicro", "00:30:48": "Supermicro",
+    "00:80:f4": "Schneider Electric", "00:0e:8c": "Siemens", "00:1b:1b": "Siemens",
+    "00:00:bc": "Rockwell Automation", "00:1d:9c": "Rockwell Automation",
+    "28:6d:cd": "Beijing Xiaomi", "64:09:80": "Xiaomi", "48:3f:da": "Espressif", "24:0a:c4": "Espressif",
+    "00:e0:fc": "Huawei", "28:6e:d4": "Huawei", "00:18:82": "Huawei", "00:0f:e2": "H3C",
+}
+
+// readARPTable returns the kernel's IP to MAC neighbour table. It is only
+// available on Linux; elsewhere it returns an empty table.
+func readARPTable() map[string]string {
+    table := make(map[string]string)
+    data, err := os.ReadFile("/proc/net/arp")
+    if err != nil {
+        return table
+    }
+    for _, line := range strings.Split(string(data), "\n")[1:] {
+        fields := strings.Fields(line)
+        // IP address, HW type, flags, HW address, mask, device; flags 0x0
+        // marks an incomplete entry.
+        if len(fields) < 4 || fields[2] == "0x0" || fields[3] == "00:00:00:00:00:00" {
+            continue
+        }
+        table[fields[0]] = strings.ToLower(fields[3])
+    }
+    return table
+}
+
+// addMACAddresses fills MAC and Vendor from the neighbour table, which the
+// scan itself has just populated for hosts on a local segment. Hosts behind
+// a router have no entry and are left untouched.
+func addMACAddresses(results []HostResult) {
+    table := readARPTable()
+    for i := range results {
+        mac, ok := table[results[i].Host]
+        if !ok {
+            continue
+        }
+        results[i].MAC = mac
+        results[i].Vendor = ouiVendors[mac[:8]]
+    }
+}
+
+// procListener is a listening socket read from /proc/net.
+type procListener struct {
+    ip    net.IP
+    port  int
+    inode string
+}
+
+// procSocketFiles are the /proc/net tables per protocol, and listenStates
+// the st column of sockets that accept traffic: LISTEN for TCP, and for UDP
+// the bound but unconnected state that the kernel reports as CLOSE.
+var (
+    procSocketFiles = map[string][]string{
+        "tcp": {"/proc/net/tcp", "/proc/net/tcp6"},
+        "udp": {"/proc/net/udp", "/proc/net/udp6"},
+    }
+    listenStates = map[string]string{"tcp": "0A", "udp": "07"}
+)
+
+// readListeners returns the listening sockets of protocol. It fails only
+// if none of the tables could be read.
+func readListeners(protocol string) ([]procListener, error) {
+    listeners := []procListener{}
+    var lastErr error
+    read := 0
+    for _, path := range procSocketFiles[protocol] {
+        data, err := os.ReadFile(path)
+        if err != nil {
+            lastErr = err
+            continue
+        }
+        read++
+        for _, line := range strings.Split(string(data), "\n")[1:] {
+            // sl, local address, remote address, st, queues, timer,
+            // retransmits, uid, timeout, inode.
+            fields := strings.Fields(line)
+            if len(fields) < 10 || fields[3] != listenStates[protocol] {
+                continue
+            }
+            ip, port, ok := parseProcAddress(fields[1])
+            if !ok {
+                continue
+            }
+            listeners = append(listeners, procListener{ip: ip, port: port, inode: fields[9]})
+        }
+    }
+    if read == 0 {
+        return nil, lastErr
+    }
+    return listeners, nil
+}
+
+// parseProcAddress decodes a /proc/net address such as "0100007F:1F90".
+// The address is hex in 32-bit words of host byte order, which is little
+// endian on every platform with this procfs layout that we run on.
+func parseProcAddress(s string) (net.IP, int, bool) {
+    addr, portHex, ok := strings.Cut(s, ":")
+    if !ok || (len(addr) != 8 && len(addr) != 32) {
+        return nil, 0, false
+    }
+    raw, err := hex.DecodeString(addr)
+    if err != nil {
+        return nil, 0, false
+    }
+    port, err := strconv.ParseUint(portHex, 16, 16)
+    if err != nil {
+        return nil, 0, false
+    }
+    ip := make(net.IP, len(raw))
+    for i := 0; i < len(raw); i += 4 {
+        ip[i], ip[i+1], ip[i+2], ip[i+3] = raw[i+3], raw[i+2], raw[i+1], raw[i]
+    }
+    return ip, int(port), true
+}
+
+// socketOwners maps the socket inodes in wanted to "name (pid N)" by
+// walking the open files of every process. Processes we may not inspect
+// are skipped, so their sockets stay unnamed.
+func socketOwners(wanted map[string]bool) map[string]string {
+    owners := make(map[string]string)
+    procs, err := os.ReadDir("/proc")
+    if err != nil {
+        return owners
+    }
+    for _, proc := range procs {
+        pid := proc.Name()
+        if _, err := strconv.Atoi(pid); err != nil {
+            continue
+        }
+        fds, err := os.ReadDir(filepath.Join("/proc", pid, "fd"))
+        if err != nil {
+            continue
+        }
+        for _, fd := range fds {
+            link, err := os.Readlink(filepath.Join("/proc", pid, "fd", fd.Name()))
+            if err != nil || !strings.HasPrefix(link, "socket:[") {
+                continue
+            }
+            inode := strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]")
+            if !wanted[inode] || owners[inode] != "" {
+                continue
+            }
+            name, _ := os.ReadFile(filepath.Join("/proc", pid, "comm"))
+            owners[inode] = fmt.Sprintf("%s (pid %s)", strings.TrimSpace(string(name)), pid)
+        }
+    }
+    return owners
+}
+
+// addProcesses fills in Process for the open ports of loopback hosts from
+// the sockets listening on this machine. It returns false when /proc/net
+// cannot be read, as on platforms other than Linux.
+func addProcesses(results []HostResult) bool {
+    listeners := map[string][]procListener{}
+    for protocol := range procSocketFiles {
+        found, err := readListeners(protocol)
+        if err != nil {
+            return false
+        }
+        listeners[protocol] = found
+    }
+    // owner finds the socket a port's traffic reaches: one bound to the
+    // host's address, or else to the wildcard address.
+    owner := func(ip net.IP, port PortResult) string {
+        inode := ""
+        for _, l := range listeners[port.Protocol] {
+            if l.port != port.Port {
+                continue
+            }
+            if l.ip.Equal(ip) {
+                return l.inode
+            }
+            if l.ip.IsUnspecified() && inode == "" {
+                inode = l.inode
+            }
+        }
+        return inode
+    }
+    inodes := map[*PortResult]string{}
+    wanted := map[string]bool{}
+    for i := range results {
+        ip := net.ParseIP(results[i].Host)
+        if ip == nil || !ip.IsLoopback() {
+            continue
+        }
+        for j := range results[i].Ports {
+            // UDP ports rarely answer, so open|filtered ones are looked up
+            // too; a bound socket shows something is listening.
+            port := &results[i].Ports[j]
+            if port.State != "open" && port.State != "open|filtered" {
+                continue
+            }
+            if inode := owner(ip, *port); inode != "" {
+                inodes[port] = inode
+                wanted[inode] = true
+            }
+        }
+    }
+    if len(wanted) == 0 {
+        return true
+    }
+    owners := socketOwners(wanted)
+    for port, inode := range inodes {
+        port.Process = owners[inode]
+    }
+    return true
+}
+
+// defaultDNSTimeout bounds each hostname lookup unless -dns-timeout is set.
+const defaultDNSTimeout = 5 * time.Second
+
+func (cfg Config) dnsTimeout() time.Duration {
+    if cfg.DNSTimeout > 0 {
+        return cfg.DNSTimeout
+    }
+    return defaultDNSTimeout
+}
+
+// resolveHosts looks up every hostname target concurrently, bounded by the
+// worker count, so DNS latency is paid once up front rather than on every
+// probe. Names that fail to resolve are reported and dropped from the scan.
+func resolveHosts(ctx context.Context, hosts []string, cfg Config) ([]string, map[string]string) {
+    resolver := cfg.Resolver
+    if resolver == nil {
+        resolver = net.DefaultResolver
+    }
+    names := []string{}
+    seen := make(map[string]bool)
+    for _, host := range hosts {
+        if net.ParseIP(host) == nil && !seen[host] {
+            seen[host] = true
+            names = append(names, host)
+        }
+    }
+    resolved := make(map[string]string)
+    failed := make(map[string]error)
+    mu := sync.Mutex{}
+    wg := sync.WaitGroup{}
+    sem := make(chan struct{}, cfg.MaxWorkers)
+    for _, name := range names {
+        wg.Add(1)
+        sem <- struct{}{}
+        go func(name string) {
+            defer wg.Done()
+            defer func() { <-sem }()
+            ctx, cancel := context.WithTimeout(ctx, cfg.dnsTimeout())
+            defer cancel()
+            addrs, err := resolver.LookupHost(ctx, name)
+            if ctx.Err() == context.DeadlineExceeded {
+                err = fmt.Errorf("lookup timed out after %v", cfg.dnsTimeout())
+            }
+            mu.Lock()
+            defer mu.Unlock()
+            if err != nil {
+                failed[name] = err
+                return
+            }
+            resolved[name] = addrs[0]
+            if cfg.ScanType != "connect" {
+                // Raw scans are IPv4 only.
+                for _, addr := range addrs {
+                    if ip := net.ParseIP(addr); ip != nil && ip.To4() != nil {
+                        resolved[name] = addr
+                        break
+                    }
+                }
+            }
+        }(name)
+    }
+    wg.Wait()
+    if len(failed) == 0 {
+        return hosts, resolved
+    }
+    fmt.Printf("[!] Skipping %d target(s) that could not be resolved:\n", len(failed))
+    for _, name := range names {
+        if err, ok := failed[name]; ok {
+            fmt.Printf("    %s: %v\n", name, err)
+        }
+    }
+    kept := []string{}
+    for _, host := range hosts {
+        if _, ok := failed[host]; !ok {
+            kept = append(kept, host)
+        }
+    }
+    return kept, resolved
+}
+
+// parseDecoys parses the -decoys list into Config.Decoys: IPv4 addresses,
+// with "ME" for the real probe's place (nil), or last if it is left out.
+func parseDecoys(list string) ([]net.IP, error) {
+    var decoys []net.IP
+    me := false
+    for _, item := range strings.Split(list, ",") {
+        item = strings.TrimSpace(item)
+        if strings.EqualFold(item, "ME") {
+            if me {
+                return nil, errors.New("ME is listed twice")
+            }
+            me = true
+            decoys = append(decoys, nil)
+            continue
+        }
+        ip := net.ParseIP(item)
+        if ip == nil || ip.To4() == nil {
+            return nil, fmt.Errorf("%q is not an IPv4 address", item)
+        }
+        decoys = append(decoys, ip.To4())
+    }
+    if !me {
+        decoys = append(decoys, nil)
+    }
+    return decoys, nil
+}
+
+// sampleHosts picks a random subset of hosts, keeping their original order.
+func sampleHosts(hosts []string, sample float64, rng *lockedRand) []string {
+    n := int(sample)
+    if sample < 1 {
+        n = int(float64(len(hosts))*sample + 0.5)
+        if n == 0 {
+            n = 1
+        }
+    }
+    if n >= len(hosts) {
+        return hosts
+    }
+    picked := rng.Perm(len(hosts))[:n]
+    sort.Ints(picked)
+    sampled := make([]string, 0, n)
+    for _, i := range picked {
+        sampled = append(sampled, hosts[i])
+    }
+    return sampled
+}
+
+// portFrequency counts, for each "port/protocol", how many hosts had it open.
+func portFrequency(results []HostResult) map[string]int {
+    freq := make(map[string]int)
+    for _, result := range results {
+        // A port listed twice with -preserve-order still counts once.
+        seen := make(map[string]bool)
+        for _, port := range result.Ports {
+            key := fmt.Sprintf("%d/%s", port.Port, port.Protocol)
+            if port.State == "open" && !seen[key] {
+                seen[key] = true
+                freq[key]++
+            }
+        }
+    }
+    return freq
+}
+
+// hostsByPort lists, for each "port/protocol", the hosts that had it open,
+// in result order: the transpose of the host-keyed results.
+func hostsByPort(results []HostResult) map[string][]string {
+    byPort := make(map[string][]string)
+    for _, result := range results {
+        seen := make(map[string]bool)
+        for _, port := range result.Ports {
+            key := fmt.Sprintf("%d/%s", port.Port, port.Protocol)
+            if port.State == "open" && !seen[key] {
+                seen[key] = true
+                byPort[key] = append(byPort[key], result.Host)
+            }
+        }
+    }
+    return byPort
+}
+
+// printByPort prints hostsByPort one port per line, "22/tcp: 10.0.0.3,
+// 10.0.0.7", in port order.
+func printByPort(byPort map[string][]string) {
+    type column struct {
+        port     int
+        protocol string
+        key      string
+    }
+    columns := make([]column, 0, len(byPort))
+    for key := range byPort {
+        number, protocol, _ := strings.Cut(key, "/")
+        port, _ := strconv.Atoi(number)
+        columns = append(columns, column{port, protocol, key})
+    }
+    sort.Slice(columns, func(i, j int) bool {
+        if columns[i].port != columns[j].port {
+            return columns[i].port < columns[j].port
+        }
+        return columns[i].protocol < columns[j].protocol
+    })
+    for _, c := range columns {
+        fmt.Printf("    %s: %s\n", c.key, strings.Join(byPort[c.key], ", "))
+    }
+}
+
+// printPortMatrix draws hosts against every port that was open on at least
+// one of them: "#" open, "?" any other reported state, "." nothing. Port
+// labels are written vertically so the columns stay narrow.
+func printPortMatrix(results []HostResult) {
+    type column struct {
+        port     int
+        protocol string
+    }
+    seen := map[column]bool{}
+    var columns []column
+    for _, result := range results {
+        for _, port := range result.Ports {
+            c := column{port.Port, port.Protocol}
+            if port.State == "open" && !seen[c] {
+                seen[c] = true
+                columns = append(columns, c)
+            }
+        }
+    }
+    if len(columns) == 0 {
+        return
+    }
+    sort.Slice(columns, func(i, j int) bool {
+        if columns[i].port != columns[j].port {
+            return columns[i].port < columns[j].port
+        }
+        return columns[i].protocol < columns[j].protocol
+    })
+
+    hostWidth := 0
+    for _, result := range results {
+        if len(result.Host) > hostWidth {
+            hostWidth = len(result.Host)
+        }
+    }
+    labels := make([]string, len(columns))
+    height := 0
+    for i, c := range columns {
+        labels[i] = fmt.Sprintf("%d/%s", c.port, c.protocol)
+        if len(labels[i]) > height {
+            height = len(labels[i])
+        }
+    }
+
+    fmt.Println("[+] Open port matrix:")
+    for row := 0; row < height; row++ {
+        line := "    " + strings.Repeat(" ", hostWidth) + " "
+        for _, label := range labels {
+            // Right-align the labels so the port numbers end on the same row.
+            if i := row - (height - len(label)); i >= 0 {
+                line += " " + string(label[i])
+            } else {
+                line += "  "
+            }
+        }
+        fmt.Println(line)
+    }
+    for _, result := range results {
+        states := map[column]string{}
+        for _, port := range result.Ports {
+            states[column{port.Port, port.Protocol}] = port.State
+        }
+        line := fmt.Sprintf("    %-*s ", hostWidth, result.Host)
+        for _, c := range columns {
+            switch states[c] {
+            case "open":
+                line += " #"
+            case "":
+                line += " ."
+            default:
+                line += " ?"
+            }
+        }
+        fmt.Println(line)
+    }
+}
+
+// resultsHash is a SHA-256 over the findings only: one (host, port,
+// protocol, state, service, banner, certificate) tuple per reported port,
+// sorted, so two scans that found the same things hash the same. Details
+// that change from run to run, such as timings, -show-source addresses,
+// -reason and process IDs, are left out.
+func resultsHash(results []HostResult) string {
+    type finding struct {
+        Host        string
+        Port        int
+        Protocol    string
+        State       string
+        Service     string
+        Banner      string
+        Certificate string
+    }
+    findings := []finding{}
+    for _, result := range results {
+        for _, port := range result.Ports {
+            f := finding{
+                Host:     result.Host,
+                Port:     port.Port,
+                Protocol: port.Protocol,
+                State:    port.State,
+                Service:  port.Service,
+                Banner:   port.Banner,
+            }
+            if port.TLS != nil {
+                f.Certificate = port.TLS.Fingerprint
+            }
+            findings = append(findings, f)
+        }
+    }
+    sort.Slice(findings, func(a, b int) bool {
+        if findings[a].Host != findings[b].Host {
+            return findings[a].Host < findings[b].Host
+        }
+        if findings[a].Port != findings[b].Port {
+            return findings[a].Port < findings[b].Port
+        }
+        return findings[a].Protocol < findings[b].Protocol
+    })
+    // encoding/json writes struct fields in declaration order, so the
+    // encoding is stable.
+    data, _ := json.Marshal(findings)
+    sum := sha256.Sum256(data)
+    return hex.EncodeToString(sum[:])
+}
+
+func printPortFrequency(freq map[string]int, limit int) {
+    keys := make([]string, 0, len(freq))
+    for key := range freq {
+        keys = append(keys, key)
+    }
+    sort.Slice(keys, func(i, j int) bool {
+        if freq[keys[i]] != freq[keys[j]] {
+            return freq[keys[i]] > freq[keys[j]]
+        }
+        return keys[i] < keys[j]
+    })
+    if len(keys) > limit {
+        keys = keys[:limit]
+    }
+    fmt.Println("[+] Most common open ports:")
+    for _, key := range keys {
+        fmt.Printf("    %s: %d host(s)\n", key, freq[key])
+    }
+}
+
+// clusterFingerprints groups hosts by the hash of their certificate (or,
+// failing that, their banner) on each port and keeps groups of two or more.
+func clusterFingerprints(results []HostResult) []FingerprintCluster {
+    groups := make(map[string]*FingerprintCluster)
+    keys := []string{}
+    for _, result := range results {
+        for _, port := range result.Ports {
+            kind, fingerprint := "", ""
+            switch {
+            case port.TLS != nil:
+                kind, fingerprint = "certificate", port.TLS.Fingerprint
+            case port.Banner != "":
+                sum := sha256.Sum256([]byte(port.Banner))
+                kind, fingerprint = "banner", hex.EncodeToString(sum[:])
+            default:
+                continue
+            }
+            label := fmt.Sprintf("%d/%s", port.Port, port.Protocol)
+            key := label + " " + kind + " " + fingerprint
+            if groups[key] == nil {
+                groups[key] = &FingerprintCluster{Port: label, Kind: kind, Fingerprint: fingerprint}
+                keys = append(keys, key)
+            }
+            groups[key].Hosts = append(groups[key].Hosts, result.Host)
+        }
+    }
+    clusters := []FingerprintCluster{}
+    for _, key := range keys {
+        if len(groups[key].Hosts) > 1 {
+            clusters = append(clusters, *groups[key])
+        }
+    }
+    sort.SliceStable(clusters, func(i, j int) bool {
+        return len(clusters[i].Hosts) > len(clusters[j].Hosts)
+    })
+    return clusters
+}
+
+// uniformMinHosts and uniformMinShare decide when hosts answering alike
+// look like one device: a group needs at least this many hosts, making up
+// at least this share of the hosts with findings.
+const (
+    uniformMinHosts = 8
+    uniformMinShare = 0.5
+)
+
+// uniformGroup is a set of hosts with the same open ports, banners and
+// certificates.
+type uniformGroup struct {
+    Fingerprint string
+    Hosts       []string
+}
+
+// hostFingerprint describes a host's answers: each reported port with its
+// certificate fingerprint or banner hash, in port order.
+func hostFingerprint(result HostResult) string {
+    parts := []string{}
+    for _, port := range result.Ports {
+        part := fmt.Sprintf("%d/%s %s", port.Port, port.Protocol, port.State)
+        switch {
+        case port.TLS != nil:
+            part += " cert:" + port.TLS.Fingerprint[:16]
+        case port.Banner != "":
+            sum := sha256.Sum256([]byte(port.Banner))
+            part += " banner:" + hex.EncodeToString(sum[:8])
+        }
+        parts = append(parts, part)
+    }
+    sort.Strings(parts)
+    return strings.Join(parts, ", ")
+}
+
+// uniformHosts finds large groups of hosts answering identically. Behind
+// CGNAT or a honeypot that is usually one device seen at many addresses.
+func uniformHosts(results []HostResult) []uniformGroup {
+    groups := make(map[string]*uniformGroup)
+    keys := []string{}
+    for _, result := range results {
+        key := hostFingerprint(result)
+        if groups[key] == nil {
+            groups[key] = &uniformGroup{Fingerprint: key}
+            keys = append(keys, key)
+        }
+        groups[key].Hosts = append(groups[key].Hosts, result.Host)
+    }
+    uniform := []uniformGroup{}
+    for _, key := range keys {
+        hosts := len(groups[key].Hosts)
+        if hosts >= uniformMinHosts && float64(hosts) >= uniformMinShare*float64(len(results)) {
+            uniform = append(uniform, *groups[key])
+        }
+    }
+    return uniform
+}
+
+// collapseUniform warns about each uniform group and returns the results
+// with only the first host of each group left in.
+func collapseUniform(results []HostResult, groups []uniformGroup) []HostResult {
+    hidden := make(map[string]bool)
+    for _, group := range groups {
+        fmt.Printf("[!] %d of %d host(s) answer identically (%s); likely one NAT/CGNAT device or a honeypot. Showing %s only.\n", len(group.Hosts), len(results), group.Fingerprint, group.Hosts[0])
+        for _, host := range group.Hosts[1:] {
+            hidden[host] = true
+        }
+    }
+    shown := []HostResult{}
+    for _, result := range results {
+        if !hidden[result.Host] {
+            shown = append(shown, result)
+        }
+    }
+    return shown
+}
+
+func printWeakTLS(results []HostResult) {
+    printed := false
+    for _, result := range results {
+        for _, port := range result.Ports {
+            weak := []string{}
+            for _, version := range port.TLSVersions {
+                if weakTLSVersions[version] {
+                    weak = append(weak, version)
+                }
+            }
+            if len(weak) == 0 {
+                continue
+            }
+            if !printed {
+                fmt.Println("[!] Weak TLS protocols accepted:")
+                printed = true
+            }
+            fmt.Printf("    %s:%d/%s %s\n", result.Host, port.Port, port.Protocol, strings.Join(weak, ", "))
+        }
+    }
+}
+
+// printExpiringCerts lists certificates found by -tls, and the per-name
+// ones found by -sni, that expire within the given number of days, marking
+// already-expired ones separately. An SNI certificate that is the port's
+// default one is listed only once.
+func printExpiringCerts(results []HostResult, days int, now time.Time) {
+    limit := now.AddDate(0, 0, days)
+    printed := false
+    check := func(endpoint string, cert *TLSInfo) {
+        if cert == nil || cert.NotAfter.After(limit) {
+            return
+        }
+        if !printed {
+            fmt.Printf("[!] Certificates expiring within %d day(s):\n", days)
+            printed = true
+        }
+        status := fmt.Sprintf("expires in %d day(s)", int(cert.NotAfter.Sub(now).Hours()/24))
+        if cert.NotAfter.Before(now) {
+            status = "EXPIRED"
+        }
+        fmt.Printf("    %s %s, %s on %s\n", endpoint, cert.Subject, status, cert.NotAfter.Format("2006-01-02"))
+    }
+    for _, result := range results {
+        for _, port := range result.Ports {
+            endpoint := fmt.Sprintf("%s:%d/%s", result.Host, port.Port, port.Protocol)
+            check(endpoint, port.TLS)
+            for _, name := range sortedKeys(port.SNICerts) {
+                cert := port.SNICerts[name]
+                if port.TLS != nil && cert != nil && cert.Fingerprint == port.TLS.Fingerprint {
+                    continue
+                }
+                check(endpoint+" SNI "+name+":", cert)
+            }
+        }
+    }
+}
+
+func sortedKeys(m map[string]*TLSInfo) []string {
+    keys := make([]string, 0, len(m))
+    for key := range m {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+func printSlowest(hosts []HostTime) {
+    fmt.Println("[+] Slowest hosts:")
+    for _, host := range hosts {
+        fmt.Printf("    %s: %v (%s)\n", host.Host, host.Elapsed.Round(time.Millisecond), host.State)
+    }
+}
+
+func printClusters(clusters []FingerprintCluster) {
+    fmt.Println("[!] Hosts sharing an identical fingerprint (load balancer or cloned image?):")
+    for _, cluster := range clusters {
+        fmt.Printf("    %s %s %.16s: %d host(s) %v\n", cluster.Port, cluster.Kind, cluster.Fingerprint, len(cluster.Hosts), cluster.Hosts)
+    }
+}
+
+func writeJSONReport(path string, report scanReport) error {
+    data, err := json.MarshalIndent(report, "", "  ")
+    if err != nil {
+        return err
+    }
+    return os.WriteFile(path, data, 0644)
+}
+
+// endpointResult is the outcome of one -endpoints line.
+type endpointResult struct {
+    Host   string `json:"host"`
+    Port   int    `json:"port"`
+    State  string `json:"state"`
+    Reason string `json:"reason,omitempty"`
+}
+
+// parseEndpoints splits host:port lines ("10.0.0.5:22", "[::1]:443").
+func parseEndpoints(lines []string) ([]endpointResult, error) {
+    endpoints := []endpointResult{}
+    for _, line := range lines {
+        host, portText, err := net.SplitHostPort(line)
+        if err != nil {
+            return nil, err
+        }
+        port, err := parsePort(portText)
+        if err != nil {
+            return nil, fmt.Errorf("%s: %v", line, err)
+        }
+        if host == "" {
+            return nil, fmt.Errorf("%s: missing host", line)
+        }
+        endpoints = append(endpoints, endpointResult{Host: normalizeHost(host), Port: port})
+    }
+    return endpoints, nil
+}
+
+// probeEndpoints runs one connect probe per endpoint, cfg.MaxWorkers at a
+// time, filling in State and Reason in place. Once ctx ends the endpoints
+// left are marked not scanned.
+func probeEndpoints(ctx context.Context, endpoints []endpointResult, cfg Config) {
+    cfg.retries = newRetryBudget(cfg.MaxRetriesTotal)
+    ch := make(chan int)
+    wg := sync.WaitGroup{}
+    for i := 0; i < cfg.MaxWorkers; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for i := range ch {
+                _, err := connectWithRetries(ctx, endpoints[i].Host, endpoints[i].Port, cfg)
+                endpoints[i].State = connectState(err, endpoints[i].Host, cfg)
+                endpoints[i].Reason = connectReason(err, endpoints[i].Host, cfg)
+                if endpoints[i].State != "open" && ctx.Err() != nil {
+                    endpoints[i].State, endpoints[i].Reason = "not-scanned", ""
+                }
+            }
+        }()
+    }
+    for i := range endpoints {
+        if ctx.Err() != nil {
+            endpoints[i].State = "not-scanned"
+            continue
+        }
+        ch <- i
+    }
+    close(ch)
+    wg.Wait()
+}
+
+// runEndpoints is the -endpoints mode: probe each listed endpoint and print
+// its state in list order. It returns an error when ctx ended the probing
+// early or outputFile could not be written.
+func runEndpoints(ctx context.Context, endpoints []endpointResult, cfg Config, outputFile string) error {
+    start := time.Now()
+    fmt.Printf("[*] Probing %d endpoint(s)...\n", len(endpoints))
+    probeEndpoints(ctx, endpoints, cfg)
+    var writeErr error
+    open := 0
+    for _, endpoint := range endpoints {
+        if endpoint.State == "open" {
+            open++
+        }
+        if cfg.Reasons {
+            fmt.Printf("    %s %s (%s)\n", net.JoinHostPort(endpoint.Host, strconv.Itoa(endpoint.Port)), endpoint.State, endpoint.Reason)
+        } else {
+            fmt.Printf("    %s %s\n", net.JoinHostPort(endpoint.Host, strconv.Itoa(endpoint.Port)), endpoint.State)
+        }
+    }
+    if outputFile != "" {
+        if !cfg.Reasons {
+            for i := range endpoints {
+                endpoints[i].Reason = ""
+            }
+        }
+        data, err := json.MarshalIndent(endpoints, "", "  ")
+        if err == nil {
+            err = os.WriteFile(outputFile, data, 0644)
+        }
+        if err != nil {
+            fmt.Printf("Error: %v\n", err)
+            writeErr = err
+        } else {
+            fmt.Printf("[+] Results written to %s\n", outputFile)
+        }
+    }
+    if ctx.Err() != nil {
+        fmt.Printf("[-] Probing interrupted after %v, the results above are partial.\n", time.Since(start))
+        return errors.New("interrupted")
+    }
+    fmt.Printf("[+] %d of %d endpoint(s) open, completed in %v.\n", open, len(endpoints), time.Since(start))
+    return writeErr
+}
+
+// readTargets reads one target (IP, CIDR or hostname) per line, skipping
+// blank lines and # comments.
+func readTargets(r io.Reader) ([]string, error) {
+    targets := []string{}
+    scanner := bufio.NewScanner(r)
+    for scanner.Scan() {
+        line := strings.TrimSpace(scanner.Text())
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+        targets = append(targets, line)
+    }
+    return targets, scanner.Err()
+}
+
+// splitTargetPorts separates the optional port column of target list lines
+// such as "10.0.0.5 22,80", returning the bare targets and the port list of
+// each target that had one.
+func splitTargetPorts(lines []string) ([]string, map[string]string, error) {
+    targets := []string{}
+    ports := map[string]string{}
+    for _, line := range lines {
+        fields := strings.Fields(line)
+        switch len(fields) {
+        case 1:
+        case 2:
+            if _, err := parsePorts(fields[1], false); err != nil {
+                return nil, nil, fmt.Errorf("%s: %v", fields[0], err)
+            }
+            if ports[fields[0]] != "" {
+                fields[1] = ports[fields[0]] + "," + fields[1]
+            }
+            ports[fields[0]] = fields[1]
+        default:
+            return nil, nil, fmt.Errorf("malformed line %q", line)
+        }
+        targets = append(targets, fields[0])
+    }
+    return targets, ports, nil
+}
+
+func readTargetsFile(path string) ([]string, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+    return readTargets(file)
+}
+
+// stdinIsPipe reports whether stdin is redirected rather than a terminal, so
+// targets are only read from it when something is actually being piped in.
+func stdinIsPipe() bool {
+    info, err := os.Stdin.Stat()
+    if err != nil {
+        return false
+    }
+    return info.Mode()&os.ModeCharDevice == 0
+}
+
+// specialUse names the special-use range an address falls in, or "" for an
+// ordinary unicast address.
+func specialUse(ip net.IP) string {
+    switch {
+    case ip.IsLoopback():
+        return "loopback"
+    case ip.IsMulticast():
+        return "multicast"
+    case ip.IsLinkLocalUnicast():
+        return "link-local"
+    case ip.IsUnspecified():
+        return "unspecified"
+    }
+    return ""
+}
+
+// checkSpecialUse warns about hosts in loopback, link-local, multicast or
+// unspecified ranges, which are usually a targeting mistake, and drops
+// multicast hosts unless cfg.AllowMulticast is set. Hosts in intended are
+// not warned about.
+func checkSpecialUse(hosts []string, intended map[string]bool, cfg Config) []string {
+    counts := map[string]int{}
+    kept := hosts[:0]
+    for _, host := range hosts {
+        kind := ""
+        if ip := net.ParseIP(cfg.address(host)); ip != nil && !intended[host] {
+            kind = specialUse(ip)
+        }
+        if kind != "" {
+            counts[kind]++
+        }
+        if kind == "multicast" && !cfg.AllowMulticast {
+            continue
+        }
+        kept = append(kept, host)
+    }
+    for _, kind := range []string{"loopback", "link-local", "unspecified"} {
+        if counts[kind] > 0 && !cfg.QuietSpecialUse {
+            fmt.Printf("[!] Warning: %d target host(s) are %s addresses\n", counts[kind], kind)
+        }
+    }
+    if counts["multicast"] > 0 {
+        if cfg.AllowMulticast {
+            if !cfg.QuietSpecialUse {
+                fmt.Printf("[!] Warning: %d target host(s) are multicast addresses\n", counts["multicast"])
+            }
+        } else {
+            fmt.Printf("[!] Skipping %d multicast address(es), use -allow-multicast to scan them\n", counts["multicast"])
+        }
+    }
+    return kept
+}
+
+// normalizeHost writes an IPv4-mapped IPv6 address such as
+// ::ffff:192.0.2.5 as the plain IPv4 address it stands for. Names and other
+// addresses are returned unchanged.
+func normalizeHost(host string) string {
+    if ip := net.ParseIP(host); ip != nil && strings.Contains(host, ":") {
+        if ip4 := ip.To4(); ip4 != nil {
+            return ip4.String()
+        }
+    }
+    return host
+}
+
+// hostLabel is host as the summary shows it: genuine IPv6 addresses are
+// marked so they stand out in dual-stack results.
+func hostLabel(host string) string {
+    if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+        return host + " (IPv6)"
+    }
+    return host
+}
+
+// dedupeHosts drops repeated hosts, keeping the first occurrence. IPs are
+// compared in canonical form, so ::ffff:10.0.0.1 and 10.0.0.1 are the same
+// host; hostnames are compared case-insensitively.
+func dedupeHosts(hosts []string) ([]string, int) {
+    seen := make(map[string]bool, len(hosts))
+    unique := hosts[:0]
+    for _, host := range hosts {
+        key := strings.ToLower(strings.TrimSuffix(host, "."))
+        if ip := net.ParseIP(host); ip != nil {
+            host = normalizeHost(host)
+            key = ip.String()
+        }
+        if !seen[key] {
+            seen[key] = true
+            unique = append(unique, host)
+        }
+    }
+    return unique, len(hosts) - len(unique)
+}
+
+// validateTarget checks a target has the syntax hostsInNetwork and the
+// resolver expect: a CIDR, an IP address or a hostname.
+func validateTarget(target string) error {
+    if strings.Contains(target, "/") {
+        _, _, err := net.ParseCIDR(target)
+        if err != nil {
+            return fmt.Errorf("invalid CIDR")
+        }
+        return nil
+    }
+    if net.ParseIP(target) != nil {
+        return nil
+    }
+    name := strings.TrimSuffix(target, ".")
+    if name == "" || len(name) > 253 {
+        return fmt.Errorf("invalid hostname")
+    }
+    numeric := true
+    for _, label := range strings.Split(name, ".") {
+        if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+            return fmt.Errorf("invalid hostname")
+        }
+        for _, c := range label {
+            switch {
+            case c >= '0' && c <= '9', c == '-':
+            case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+                numeric = false
+            default:
+                return fmt.Errorf("invalid character %q", c)
+            }
+        }
+    }
+    // Something like 192.168.1.300 or 10.0.0.1-20 is a mistyped address,
+    // not a hostname.
+    if numeric {
+        return fmt.Errorf("invalid IP address")
+    }
+    return nil
+}
+
+// broadTargets lists the CIDR targets shorter than minPrefix. IPv6 prefixes
+// are held to the same host count, e.g. /16 for IPv4 allows /112 for IPv6.
+func broadTargets(targets []string, minPrefix int) []string {
+    var broad []string
+    for _, target := range targets {
+        _, ipNet, err := net.ParseCIDR(target)
+        if err != nil {
+            continue
+        }
+        ones, bits := ipNet.Mask.Size()
+        if bits-ones > 32-minPrefix {
+            broad = append(broad, target)
+        }
+    }
+    return broad
+}
+
+// validateTargets reports every invalid target at once.
+func validateTargets(targets []string) error {
+    var problems []string
+    for _, target := range targets {
+        if err := validateTarget(target); err != nil {
+            problems = append(problems, fmt.Sprintf("    %s: %v", target, err))
+        }
+    }
+    if len(problems) > 0 {
+        return fmt.Errorf("%d invalid target(s):\n%s", len(problems), strings.Join(problems, "\n"))
+    }
+    return nil
+}
+
+func isLocalhost(target string) bool {
+    return strings.EqualFold(strings.TrimSuffix(target, "."), "localhost")
+}
+
+// localhostAddrs is 127.0.0.1, plus ::1 when IPv6 loopback is configured.
+func localhostAddrs() []string {
+    hosts := []string{"127.0.0.1"}
+    addrs, err := net.InterfaceAddrs()
+    if err != nil {
+        return hosts
+    }
+    for _, addr := range addrs {
+        if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(net.IPv6loopback) {
+            return append(hosts, "::1")
+        }
+    }
+    return hosts
+}
+
+// hostsInNetwork expands a CIDR into its addresses. "localhost" is both
+// loopback addresses; any other bare IP or hostname is returned as the
+// single host to scan. A CIDR with host bits set, like 192.168.1.37/24, is
+// enumerated from the network address unless fromHost is set, in which case
+// it starts at the given address (.37) and runs to the end of the range.
+func hostsInNetwork(network string, fromHost bool) ([]string, error) {
+    ips := []string{}
+    if isLocalhost(network) {
+        return localhostAddrs(), nil
+    }
+    if !strings.Contains(network, "/") {
+        return append(ips, network), nil
+    }
+    ip, ipNet, err := net.ParseCIDR(network)
+    if err != nil {
+        return ips, err
+    }
+    start := ip.Mask(ipNet.Mask)
+    if fromHost {
+        start = ip
+        if ip4 := ip.To4(); ip4 != nil {
+            start = ip4
+        }
+    }
+    for ip := start; ipNet.Contains(ip); inc(ip) {
+        ips = append(ips, ip.String())
+    }
+    lenIps := len(ips)
+    switch {
+    case lenIps == 0:
+        return ips, fmt.Errorf("no IP addresses found in network")
+    default:
+        return ips, nil
+    }
+}
+
+// hostBitsSet reports whether a CIDR target names an address inside the
+// network rather than the network itself, returning the network.
+func hostBitsSet(target string) (string, bool) {
+    ip, ipNet, err := net.ParseCIDR(target)
+    if err != nil || ip.Equal(ipNet.IP) {
+        return "", false
+    }
+    return ipNet.String(), true
+}
+
+func inc(ip net.IP) {
+    for j := len(ip) - 1; j >= 0; j-- {
+        ip[j]++
+        if ip[j] > 0 {
+            break
+        }
+    }
+}
+
+var (
+    network   string
+    inputList string
+    endpointsFile string
+    scanTag   string
+    portRange string
+    protoList string
+    timeout   int
+    hostTimeout time.Duration
+    dnsTimeoutFlag time.Duration
+    maxWorkers int
+    verbose   bool
+    outputFile string
+    sample    float64
+    seed      int64
+    banners   bool
+    tlsInspect bool
+    clusterHosts bool
+    collapseIdentical bool
+    excludeHoneypots  bool
+    onlyPort          string
+    envProxy  bool
+    jitter    time.Duration
+    synScan   bool
+    finScan   bool
+    nullScan  bool
+    xmasScan  bool
+    ackScan   bool
+    decoyList string
+    favicon   bool
+    httpProbe  bool
+    httpMethod string
+    httpPath   string
+    tlsEnum   bool
+    certExpiryDays int
+    sniList   string
+    resolverAddr string
+    lookupNames bool
+    arpLookup bool
+    identifyProcess bool
+    geoIPPath string
+    selfTest  bool
+    showVersion bool
+    listPorts   bool
+    configFile string
+    liveTUI   bool
+    showProgress bool
+    portMatrix bool
+    byPort     bool
+    maxBandwidth string
+    maxConnsPerHost int
+    retries   int
+    resumeFile string
+    cacheTTL   time.Duration
+    cacheFile  string
+    scheduleSpec string
+    readTimeout int
+    hashResults bool
+    notifySpecs stringListFlag
+    notifiers []notifier
+    minPrefix int
+    assumeYes bool
+    quietSpecialUse bool
+    allowMulticast bool
+    bannerBytes int
+    rawBanner bool
+    adaptive  bool
+    appendLog string
+    eventsFile string
+    showReasons bool
+    showSource bool
+    abortOpen  bool
+    maxRetriesTotal int
+    fromHost  bool
+    preserveOrder bool
+    flat          bool
+    strict        bool
+    sequential    bool
+    slowest       int
+    requirePorts  bool
+    maxGoroutines int
+)
+
+func init() {
+    flag.StringVar(&network, "n", "", "Networks to scan, comma separated (e.g. \"192.168.0.1\" or \"192.168.0.0/24,10.0.0.0/28\"), env HR_NETWORK")
+    flag.IntVar(&maxGoroutines, "max-goroutines", 0, "Cap the goroutines the scan starts by running probes on a fixed pool (0 = one goroutine per probe)")
+    flag.BoolVar(&requirePorts, "require-ports", false, "Refuse to scan without -p instead of falling back to the built-in list (use -p default for it)")
+    flag.IntVar(&slowest, "slowest", 0, "List the N hosts that took longest to scan, which often points at filtering or packet loss")
+    flag.BoolVar(&sequential, "sequential", false, "Probe each host's ports one at a time, in order, for fragile devices or port knocking setups (slow)")
+    flag.BoolVar(&strict, "strict", false, "Exit non-zero if any probe failed on a local or network error (e.g. too many open files, no route), even if the scan finished")
+    flag.BoolVar(&flat, "flat", false, "Probe all (host, port) pairs from one pool of workers instead of -w hosts at a time; the pool is -w probes, or what -max-goroutines allows")
+    flag.BoolVar(&preserveOrder, "preserve-order", false, "Probe ports in the order given to -p, without sorting or removing duplicates")
+    flag.BoolVar(&fromHost, "from-host", false, "For a CIDR with host bits set (e.g. 192.168.1.37/24), start at that address instead of the network address")
+    flag.IntVar(&minPrefix, "min-prefix", 16, "Refuse IPv4 CIDRs shorter than this prefix (IPv6: same host count) unless -yes is given")
+    flag.BoolVar(&assumeYes, "yes", false, "Scan targets broader than -min-prefix without refusing")
+    flag.BoolVar(&quietSpecialUse, "no-special-warn", false, "Don't warn about loopback, link-local, multicast or unspecified targets")
+    flag.BoolVar(&allowMulticast, "allow-multicast", false, "Scan multicast targets instead of skipping them")
+    flag.StringVar(&configFile, "config", "", "Read settings and targets from a JSON (.json) or YAML file; flags and HR_* variables override it")
+    flag.StringVar(&scanTag, "tag", "", "Label the scan (e.g. \"prod-weekly\"): recorded in -o metadata, notifications and -schedule file names")
+    flag.StringVar(&endpointsFile, "endpoints", "", "Probe exactly the host:port pairs in this file, one per line (\"-\" for stdin), instead of -n/-p")
+    flag.StringVar(&inputList, "iL", "", "Read targets from a file, one per line with an optional port list replacing -p for it, e.g. \"10.0.0.5 22,80\" (stdin is read when piped and -n is absent)")
+    flag.Var((*appendFlag)(&portRange), "p", "Ports to scan (e.g. \"80\", \"1-65535\", \"default\" for the built-in list or \"ot\" for OT/ICS ports); repeats add up; without it UDP gets its own list, env HR_PORTS")
+    flag.StringVar(&protoList, "proto", "tcp", "Protocols to scan, comma separated (e.g. \"tcp\", \"udp\" or \"tcp,udp\")")
+    flag.IntVar(&timeout, "connect-timeout", 500, "TCP connection timeout in milliseconds, env HR_TIMEOUT")
+    flag.IntVar(&timeout, "t", 500, "Alias for -connect-timeout")
+    flag.IntVar(&readTimeout, "read-timeout", 0, "Timeout in milliseconds for reading banners and TLS/HTTP replies once connected, 0 uses the connect timeout")
+    flag.DurationVar(&dnsTimeoutFlag, "dns-timeout", defaultDNSTimeout, "Give up resolving a target hostname after this long and skip it")
+    flag.DurationVar(&hostTimeout, "host-timeout", 0, "Give up on a host after this long (e.g. \"30s\"), 0 disables")
+    flag.IntVar(&maxWorkers, "w", 100, "Maximum number of worker threads for the scan, env HR_WORKERS")
+    flag.BoolVar(&adaptive, "adaptive", false, "Share probes between hosts by responsiveness: answering hosts get more in flight, timing-out hosts fewer")
+    flag.IntVar(&maxConnsPerHost, "max-conns-per-host", 0, "Maximum concurrent probes against any one host, 0 for no limit")
+    flag.IntVar(&retries, "retries", 0, "Retry connect probes that time out or hit transient errors (e.g. too many open files) this many times, backing off from 100ms up to 2s")
+    flag.BoolVar(&verbose, "v", false, "Verbose output")
+    flag.BoolVar(&showProgress, "progress", false, "Show progress on stderr: a bar on a terminal, a \"N/M hosts done\" line every 10s otherwise")
+    flag.BoolVar(&liveTUI, "tui", false, "Show a live, scrollable table of hosts while scanning (j/k scroll, / filter, q quit when done)")
+    flag.BoolVar(&showVersion, "version", false, "Print version and build information, then exit")
+    flag.BoolVar(&listPorts, "list-ports", false, "Print the ports -p (or the default list) expands to for each -proto, with service names, then exit")
+    flag.BoolVar(&selfTest, "selftest", false, "Scan a temporary loopback listener to check the tool works here, then exit")
+    flag.StringVar(&scheduleSpec, "schedule", "", "Rerun the scan on a cron schedule (e.g. \"0 2 * * *\" or \"@hourly\"); -o files get a timestamp")
+    flag.DurationVar(&cacheTTL, "cache", 0, "Reuse probe results younger than this (e.g. \"5m\") from the cache file instead of probing again, 0 disables")
+    flag.StringVar(&cacheFile, "cache-file", "", "Cache file for -cache (default in the user cache directory)")
+    flag.StringVar(&resumeFile, "resume", "", "Checkpoint file: skip the host/port probes it lists as done and record new ones; removed when the scan completes")
+    flag.StringVar(&eventsFile, "events-file", "", "Write NDJSON progress events (scan_start, host_complete, scan_complete) to this file, e.g. /dev/fd/3")
+    flag.StringVar(&appendLog, "append-log", "", "Append each host's findings to this NDJSON file as soon as the host completes")
+    flag.StringVar(&outputFile, "o", "", "Write results as JSON to this file")
+    flag.Float64Var(&sample, "sample", 0, "Scan a random subset of hosts: a fraction below 1 (e.g. 0.1) or a host count (e.g. 500)")
+    flag.Int64Var(&seed, "seed", 0, "Random seed for reproducible sampling and jitter, 0 picks one")
+    flag.StringVar(&maxBandwidth, "max-bandwidth", "", "Cap data read and written by banner/TLS/HTTP probes, in bytes per second (e.g. \"256KB\", \"1MB\")")
+    flag.DurationVar(&jitter, "jitter", 0, "Wait a random delay up to this long before each probe (e.g. \"50ms\")")
+    flag.BoolVar(&synScan, "sS", false, "Half-open SYN scan over raw sockets (IPv4 only, needs root/CAP_NET_RAW)")
+    flag.BoolVar(&finScan, "sF", false, "FIN scan over raw sockets; Windows targets report every port closed")
+    flag.BoolVar(&nullScan, "sN", false, "NULL scan (no flags) over raw sockets; Windows targets report every port closed")
+    flag.BoolVar(&ackScan, "sA", false, "ACK scan over raw sockets, reports unfiltered/filtered instead of open/closed")
+    flag.StringVar(&decoyList, "decoys", "", "With -sS, also send each probe from these spoofed IPv4 addresses, e.g. \"10.0.0.5,ME,10.0.0.9\" (ME is the real probe, last if left out; Linux, root/CAP_NET_RAW)")
+    flag.BoolVar(&xmasScan, "sX", false, "XMAS scan (FIN/PSH/URG) over raw sockets; Windows targets report every port closed")
+    flag.IntVar(&maxRetriesTotal, "max-retries-total", 0, "Cap the retries of the whole scan, 0 for no cap beyond -retries per probe")
+    flag.BoolVar(&abortOpen, "abort-open", false, "Close open connect probes with a RST instead of a graceful FIN")
+    flag.BoolVar(&showSource, "show-source", false, "Show the local address and port each open connect probe came from, to match against the target's logs")
+    flag.BoolVar(&showReasons, "reason", false, "Show why each port got its state (syn-ack, conn-refused, reset-after-connect, ...)")
+    flag.BoolVar(&banners, "banner", false, "Grab the banner of open TCP ports")
+    flag.BoolVar(&rawBanner, "raw-banner", false, "Print banners verbatim instead of escaping control and non-printable bytes")
+    flag.IntVar(&bannerBytes, "banner-bytes", 1024, "Read at most this many bytes of each banner")
+    flag.BoolVar(&tlsInspect, "tls", false, "Record the TLS certificate of open TCP ports")
+    flag.BoolVar(&favicon, "favicon", false, "Record the mmh3 hash of /favicon.ico on open HTTP(S) ports")
+    flag.BoolVar(&httpProbe, "http", false, "Send an HTTP(S) request to open TCP ports and record the status, Server header and page title")
+    flag.StringVar(&httpMethod, "http-method", http.MethodGet, "Method of the -http request (implies -http)")
+    flag.StringVar(&httpPath, "http-path", "/", "Path of the -http request, e.g. /healthz (implies -http)")
+    flag.StringVar(&geoIPPath, "geoip", "", "MaxMind DB (City, Country or ASN .mmdb) to annotate public hosts with")
+    flag.BoolVar(&arpLookup, "arp", false, "Report MAC address and vendor of hosts on the local segment (Linux)")
+    flag.BoolVar(&identifyProcess, "identify-process", false, "Name the local process listening on each open port of loopback targets (Linux)")
+    flag.BoolVar(&lookupNames, "names", false, "Look up hostnames of hosts with findings via reverse DNS, then mDNS and NetBIOS")
+    flag.StringVar(&resolverAddr, "resolver", "", "DNS server for all lookups (e.g. \"8.8.8.8:53\"), default is the system resolver")
+    flag.BoolVar(&envProxy, "env-proxy", false, "Route TCP probes through the SOCKS5 proxy in ALL_PROXY, honouring NO_PROXY")
+    flag.IntVar(&certExpiryDays, "cert-expiry-days", 30, "With -tls, list certificates expiring within this many days")
+    flag.StringVar(&sniList, "sni-list", "", "File of hostnames to send as SNI to open TCP ports, recording the certificate returned for each")
+    flag.BoolVar(&tlsEnum, "tls-enum", false, "Enumerate the TLS versions and cipher suites accepted by open TCP ports")
+    flag.Var(&notifySpecs, "notify", "Post a summary to slack:WEBHOOK_URL or discord:WEBHOOK_URL; repeatable. With -schedule only newly open ports are posted")
+    flag.BoolVar(&hashResults, "hash", false, "Print a SHA-256 of the sorted findings to spot changes between runs")
+    flag.BoolVar(&portMatrix, "matrix", false, "Print a hosts x open ports matrix after the scan")
+    flag.BoolVar(&byPort, "by-port", false, "List results by port instead of by host (\"22/tcp: 10.0.0.3, 10.0.0.7\"), and add by_port to the -o JSON")
+    flag.StringVar(&onlyPort, "only-port", "", "Only report hosts with one of these ports open, e.g. \"3389\" or \"22,3389\"")
+    flag.BoolVar(&excludeHoneypots, "exclude-honeypots", false, "Leave hosts with over 95% of probed ports open (likely honeypots) out of the results")
+    flag.BoolVar(&collapseIdentical, "collapse-identical", false, "Warn when most hosts answer identically (NAT/CGNAT or honeypot) and list only one of them")
+    flag.BoolVar(&clusterHosts, "clusters", false, "Flag hosts sharing a banner or certificate (use with -banner/-tls)")
+
+    flag.Usage = func() {
+        fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", os.Args[0])
+        flag.PrintDefaults()
+        fmt.Fprintln(flag.CommandLine.Output(), "\nSettings are taken from command-line flags first, then HR_* environment variables, then the -config file, then built-in defaults.")
+    }
+}
+
+// envFlags maps the flags that can be configured from the environment to
+// their variable names.
+var envFlags = []struct {
+    flag string
+    env  string
+}{
+    {"n", "HR_NETWORK"},
+    {"p", "HR_PORTS"},
+    {"connect-timeout", "HR_TIMEOUT"},
+    {"w", "HR_WORKERS"},
+}
+
+// applyEnvironment fills in flags that were not given on the command line
+// from their HR_* environment variables, so flags win over the environment
+// and the environment wins over the config file and the defaults.
+func applyEnvironment(explicit map[string]bool) error {
+    for _, e := range envFlags {
+        value, ok := os.LookupEnv(e.env)
+        if !ok || value == "" || explicit[e.flag] {
+            continue
+        }
+        if err := setFallback(e.flag, value); err != nil {
+            return fmt.Errorf("invalid %s %q: %v", e.env, value, err)
+        }
+    }
+    return nil
+}
+
+// setFallback sets a flag that was not given on the command line. Only
+// command-line repeats of a list flag add up: a value from the environment
+// replaces one from the config file rather than joining it.
+func setFallback(name, value string) error {
+    if f, ok := flag.Lookup(name).Value.(*appendFlag); ok {
+        *f = ""
+    }
+    return flag.Set(name, value)
+}
+
+// configKeys maps the friendlier config file keys onto flag names. Any
+// other flag can be set by its own name, with "_" accepted for "-".
+var configKeys = map[string]string{
+    "ports":     "p",
+    "protocols": "proto",
+    "timeout":   "connect-timeout",
+    "workers":   "w",
+    "verbose":   "v",
+    "output":    "o",
+}
+
+// loadConfigFile applies a JSON (.json) or YAML config file to the flags that
+// were not given on the command line and returns the targets it lists. Keys
+// that match no flag are reported and skipped.
+func loadConfigFile(path string, explicit map[string]bool) ([]string, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    var values map[string][]string
+    if strings.EqualFold(filepath.Ext(path), ".json") {
+        values, err = parseJSONConfig(data)
+    } else {
+        values, err = parseYAMLConfig(data)
+    }
+    if err != nil {
+        return nil, fmt.Errorf("%s: %v", path, err)
+    }
+
+    keys := make([]string, 0, len(values))
+    for key := range values {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+
+    var targets []string
+    for _, key := range keys {
+        value := values[key]
+        if key == "targets" || key == "network" {
+            if !explicit["n"] && !explicit["iL"] {
+                targets = append(targets, value...)
+            }
+            continue
+        }
+        name, ok := configKeys[key]
+        if !ok {
+            name = strings.ReplaceAll(key, "_", "-")
+        }
+        if name == "config" || flag.Lookup(name) == nil {
+            fmt.Printf("[!] Warning: %s: unknown key %q ignored\n", path, key)
+            continue
+        }
+        if explicit[name] {
+            continue
+        }
+        if err := setFallback(name, strings.Join(value, ",")); err != nil {
+            return nil, fmt.Errorf("%s: invalid %s: %v", path, key, err)
+        }
+    }
+    return targets, nil
+}
+
+func parseJSONConfig(data []byte) (map[string][]string, error) {
+    var raw map[string]interface{}
+    if err := json.Unmarshal(data, &raw); err != nil {
+        return nil, err
+    }
+    values := map[string][]string{}
+    for key, value := range raw {
+        items, ok := value.([]interface{})
+        if !ok {
+            items = []interface{}{value}
+        }
+        for _, item := range items {
+            switch v := item.(type) {
+            case string:
+                values[key] = append(values[key], v)
+            case float64:
+                values[key] = append(values[key], strconv.FormatFloat(v, 'f', -1, 64))
+            case bool:
+                values[key] = append(values[key], strconv.FormatBool(v))
+            default:
+                return nil, fmt.Errorf("unsupported value for %q", key)
+            }
+        }
+    }
+    return values, nil
+}
+
+// parseYAMLConfig reads the flat subset of YAML a scan config needs:
+// "key: value" pairs, "# comments", and lists written either inline as
+// "[a, b]" or as "- item" lines under an empty key.
+func parseYAMLConfig(data []byte) (map[string][]string, error) {
+    values := map[string][]string{}
+    listKey := ""
+    for n, line := range strings.Split(string(data), "\n") {
+        line, err := stripYAMLComment(line)
+        if err != nil {
+            return nil, fmt.Errorf("line %d: %v", n+1, err)
+        }
+        trimmed := strings.TrimSpace(line)
+        if trimmed == "" || strings.HasPrefix(trimmed, "#") || trimmed == "---" {
+            continue
+        }
+        if strings.HasPrefix(trimmed, "- ") || trimmed == "-" {
+            if listKey == "" {
+                return nil, fmt.Errorf("line %d: list item outside a list", n+1)
+            }
+            values[listKey] = append(values[listKey], unquoteYAML(strings.TrimSpace(trimmed[1:])))
+            continue
+        }
+        colon := strings.Index(trimmed, ":")
+        if colon <= 0 || line[0] == ' ' || line[0] == '\t' {
+            return nil, fmt.Errorf("line %d: expected \"key: value\"", n+1)
+        }
+        key := strings.TrimSpace(trimmed[:colon])
+        value := strings.TrimSpace(trimmed[colon+1:])
+        listKey = ""
+        switch {
+        case value == "":
+            listKey = key
+            values[key] = nil
+        case strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]"):
+            for _, item := range strings.Split(value[1:len(value)-1], ",") {
+                if item = strings.TrimSpace(item); item != "" {
+                    values[key] = append(values[key], unquoteYAML(item))
+                }
+            }
+        default:
+            values[key] = []string{unquoteYAML(value)}
+        }
+    }
+    return values, nil
+}
+
+// stripYAMLComment cuts a "#" comment, at the start of the line or after
+// whitespace, off line. A "#" inside a quoted value is kept: quotes open
+// where a value or list item starts, so an apostrophe in a plain value
+// is just a character.
+func stripYAMLComment(line string) (string, error) {
+    var quote byte
+    boundary := true
+    for i := 0; i < len(line); i++ {
+        c := line[i]
+        switch {
+        case quote != 0:
+            if c == quote {
+                quote = 0
+            }
+        case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+            return line[:i], nil
+        case (c == '"' || c == '\'') && boundary:
+            quote = c
+        }
+        if c != ' ' && c != '\t' {
+            boundary = quote == 0 && (c == ':' || c == '[' || c == ',' || c == '-')
+        }
+    }
+    if quote != 0 {
+        return "", fmt.Errorf("unterminated %c quote", quote)
+    }
+    return line, nil
+}
+
+func unquoteYAML(value string) string {
+    if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+        return value[1 : len(value)-1]
+    }
+    return value
+}
+
+// progressReporter is -progress. On a terminal it redraws a bar in place on
+// one line; otherwise it writes a plain "N/M hosts done" line now and then,
+// so a redirected log does not fill up with carriage returns.
+type progressReporter struct {
+    mu    sync.Mutex
+    out   *os.File
+    tty   bool
+    start time.Time
+    last  time.Time
+    drawn bool
+}
+
+const (
+    progressBarWidth    = 30
+    progressRedraw      = 100 * time.Millisecond
+    progressLogInterval = 10 * time.Second
+)
+
+func newProgressReporter(out *os.File) *progressReporter {
+    now := time.Now()
+    return &progressReporter{out: out, tty: isTerminal(out), start: now, last: now}
+}
+
+// update is the Config.progress hook.
+func (p *progressReporter) update(done, total int, result *HostResult) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    interval := progressLogInterval
+    if p.tty {
+        interval = progressRedraw
+    }
+    if done < total && time.Since(p.last) < interval {
+        return
+    }
+    p.last = time.Now()
+    percent := 100
+    eta := time.Duration(0)
+    if total > 0 {
+        percent = 100 * done / total
+    }
+    if done > 0 {
+        elapsed := time.Since(p.start)
+        eta = elapsed / time.Duration(done) * time.Duration(total-done)
+    }
+    if p.tty {
+        filled := progressBarWidth * percent / 100
+        fmt.Fprintf(p.out, "\r\x1b[K[%s%s] %d/%d hosts %d%% ETA %v", strings.Repeat("#", filled), strings.Repeat("-", progressBarWidth-filled), done, total, percent, eta.Round(time.Second))
+        p.drawn = true
+        return
+    }
+    fmt.Fprintf(p.out, "[*] Progress: %d/%d hosts done (%d%%), ETA %v\n", done, total, percent, eta.Round(time.Second))
+}
+
+// close ends the bar's line so later output starts on a fresh one.
+func (p *progressReporter) close() {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    if p.drawn {
+        fmt.Fprintln(p.out)
+    }
+}
+
+// liveView is the -tui screen: a progress bar, throughput and a scrollable,
+// filterable table of hosts with open ports, redrawn as hosts complete. It
+// uses plain ANSI escapes and stty rather than a TUI library so the tool
+// stays dependency free.
+type liveView struct {
+    mu       sync.Mutex
+    start    time.Time
+    done     int
+    total    int
+    openPorts int
+    rows     []HostResult
+    offset   int
+    filter   string
+    editing  bool
+    finished bool
+    elapsed  time.Duration
+    closed   bool
+    // keys is set when stdin is a terminal in character mode.
+    keys     bool
+    // height and width are the terminal size, read again on SIGWINCH
+    // rather than on every redraw.
+    height   int
+    width    int
+    pause    *pauseGate
+    // interrupted ends the wait for q once the scan has been interrupted.
+    interrupted <-chan struct{}
+    quit     chan struct{}
+    stop     chan struct{}
+}
+
+func isTerminal(f *os.File) bool {
+    info, err := f.Stat()
+    if err != nil {
+        return false
+    }
+    return info.Mode()&os.ModeCharDevice != 0
+}
+
+func stty(args ...string) (string, error) {
+    cmd := exec.Command("stty", args...)
+    cmd.Stdin = os.Stdin
+    out, err := cmd.Output()
+    return strings.TrimSpace(string(out)), err
+}
+
+// savedTerminal is the stty state of stdin from before characterMode, ""
+// when the terminal is as the user left it.
+var (
+    terminalMu    sync.Mutex
+    savedTerminal string
+)
+
+// characterMode puts the terminal on stdin in character mode without echo,
+// so single keys can be read. restoreTerminal puts it back.
+func characterMode() error {
+    terminalMu.Lock()
+    defer terminalMu.Unlock()
+    if savedTerminal != "" {
+        return nil
+    }
+    state, err := stty("-g")
+    if err != nil {
+        return err
+    }
+    if _, err := stty("-icanon", "-echo", "min", "1"); err != nil {
+        return err
+    }
+    savedTerminal = state
+    return nil
+}
+
+// restoreTerminal undoes characterMode. Every way out of the program must
+// call it, or the shell is left without echo; it is safe to call again.
+func restoreTerminal() {
+    terminalMu.Lock()
+    defer terminalMu.Unlock()
+    if savedTerminal != "" {
+        stty(savedTerminal)
+        savedTerminal = ""
+    }
+}
+
+var (
+    keysOnce sync.Once
+    keys     chan string
+)
+
+// terminalKeys delivers what is typed on stdin, one read at a time, to
+// whichever part of the tool is listening. A single reader is shared because
+// a blocked read on stdin cannot be cancelled.
+func terminalKeys() <-chan string {
+    keysOnce.Do(func() {
+        keys = make(chan string)
+        go func() {
+            defer close(keys)
+            buf := make([]byte, 16)
+            for {
+                n, err := os.Stdin.Read(buf)
+                if err != nil {
+                    return
+                }
+                keys <- string(buf[:n])
+            }
+        }()
+    })
+    return keys
+}
+
+// pauseGate holds back new probes while paused; probes already running
+// finish normally.
+type pauseGate struct {
+    mu      sync.Mutex
+    resumed chan struct{}
+}
+
+func (g *pauseGate) pause() {
+    g.mu.Lock()
+    defer g.mu.Unlock()
+    if g.resumed == nil {
+        g.resumed = make(chan struct{})
+    }
+}
+
+func (g *pauseGate) resume() {
+    g.mu.Lock()
+    defer g.mu.Unlock()
+    if g.resumed != nil {
+        close(g.resumed)
+        g.resumed = nil
+    }
+}
+
+func (g *pauseGate) paused() bool {
+    g.mu.Lock()
+    defer g.mu.Unlock()
+    return g.resumed != nil
+}
+
+// wait blocks while the gate is paused or until ctx is done. A nil gate
+// never pauses.
+func (g *pauseGate) wait(ctx context.Context) {
+    if g == nil {
+        return
+    }
+    g.mu.Lock()
+    resumed := g.resumed
+    g.mu.Unlock()
+    if resumed == nil {
+        return
+    }
+    select {
+    case <-resumed:
+    case <-ctx.Done():
+    }
+}
+
+// watchPauseKeys lets p pause and r resume the scan when stdin is a
+// terminal. The returned function stops watching and restores the terminal.
+func watchPauseKeys(gate *pauseGate) func() {
+    if !isTerminal(os.Stdin) || characterMode() != nil {
+        return func() {}
+    }
+    fmt.Println("[*] Press p to pause and r to resume the scan.")
+    stop := make(chan struct{})
+    go func() {
+        keys := terminalKeys()
+        for {
+            select {
+            case <-stop:
+                return
+            case k, ok := <-keys:
+                if !ok {
+                    return
+                }
+                switch {
+                case k == "p" && !gate.paused():
+                    gate.pause()
+                    fmt.Println("[*] Paused: probes in flight will finish, press r to resume.")
+                case k == "r" && gate.paused():
+                    gate.resume()
+                    fmt.Println("[*] Resumed.")
+                }
+            }
+        }
+    }()
+    return func() {
+        close(stop)
+        gate.resume()
+        restoreTerminal()
+    }
+}
+
+// terminalSize returns the rows and columns of the terminal, falling back to
+// 24x80 when stty cannot tell.
+func terminalSize() (int, int) {
+    size, err := stty("size")
+    if err == nil {
+        var rows, cols int
+        if _, err := fmt.Sscanf(size, "%d %d", &rows, &cols); err == nil && rows > 0 && cols > 0 {
+            return rows, cols
+        }
+    }
+    return 24, 80
+}
+
+// sigwinch is SIGWINCH on Linux, macOS and the BSDs. syscall has no name
+// for it on Windows, which never sends it.
+const sigwinch = syscall.Signal(0x1c)
+
+// newLiveView switches to the alternate screen and, when stdin is a
+// terminal, puts it in character mode so keys work without Enter. The view
+// stops waiting for q to close once ctx is done.
+func newLiveView(ctx context.Context, pause *pauseGate) *liveView {
+    v := &liveView{start: time.Now(), pause: pause, interrupted: ctx.Done(), quit: make(chan struct{}), stop: make(chan struct{})}
+    v.height, v.width = terminalSize()
+    if isTerminal(os.Stdin) && characterMode() == nil {
+        v.keys = true
+        go v.readKeys()
+    }
+    fmt.Print("\x1b[?1049h\x1b[?25l")
+    resized := make(chan os.Signal, 1)
+    signal.Notify(resized, sigwinch)
+    go func() {
+        ticker := time.NewTicker(250 * time.Millisecond)
+        defer ticker.Stop()
+        defer signal.Stop(resized)
+        for {
+            select {
+            case <-v.stop:
+                return
+            case <-resized:
+                height, width := terminalSize()
+                v.mu.Lock()
+                v.height, v.width = height, width
+                v.mu.Unlock()
+                v.draw()
+            case <-ticker.C:
+                v.draw()
+            }
+        }
+    }()
+    return v
+}
+
+// update is the Config.progress hook.
+func (v *liveView) update(done, total int, result *HostResult) {
+    v.mu.Lock()
+    v.done, v.total = done, total
+    if result != nil {
+        v.rows = append(v.rows, *result)
+        v.openPorts += len(result.Ports)
+    }
+    v.mu.Unlock()
+}
+
+// close waits for q when keys can be read, so the final table can still be
+// browsed, unless the scan was interrupted, then restores the terminal.
+func (v *liveView) close() {
+    v.mu.Lock()
+    v.finished = true
+    v.elapsed = time.Since(v.start)
+    v.mu.Unlock()
+    v.draw()
+    if v.keys {
+        select {
+        case <-v.quit:
+        case <-v.interrupted:
+        }
+    }
+    close(v.stop)
+    v.mu.Lock()
+    v.closed = true
+    v.mu.Unlock()
+    restoreTerminal()
+    fmt.Print("\x1b[?25h\x1b[?1049l")
+}
+
+func (v *liveView) readKeys() {
+    keys := terminalKeys()
+    for {
+        select {
+        case <-v.stop:
+            return
+        case k, ok := <-keys:
+            if !ok {
+                return
+            }
+            v.key(k)
+        }
+    }
+}
+
+func (v *liveView) key(k string) {
+    v.mu.Lock()
+    if v.closed {
+        v.mu.Unlock()
+        return
+    }
+    if v.editing {
+        switch {
+        case k == "\r" || k == "\n":
+            v.editing = false
+        case k == "\x1b":
+            v.editing = false
+            v.filter = ""
+        case k == "\x7f" || k == "\b":
+            if len(v.filter) > 0 {
+                v.filter = v.filter[:len(v.filter)-1]
+            }
+        case len(k) == 1 && k[0] >= ' ':
+            v.filter += k
+        }
+        v.offset = 0
+        v.mu.Unlock()
+        v.draw()
+        return
+    }
+    switch k {
+    case "q":
+        if v.finished {
+            select {
+            case <-v.quit:
+            default:
+                close(v.quit)
+            }
+            v.mu.Unlock()
+            return
+        }
+    case "/":
+        v.editing = true
+    case "j", "\x1b[B":
+        v.offset++
+    case "k", "\x1b[A":
+        v.offset--
+    case " ", "\x1b[6~":
+        v.offset += 10
+    case "b", "\x1b[5~":
+        v.offset -= 10
+    case "g":
+        v.offset = 0
+    case "p":
+        v.pause.pause()
+    case "r":
+        v.pause.resume()
+    }
+    v.mu.Unlock()
+    v.draw()
+}
+
+func (v *liveView) draw() {
+    v.mu.Lock()
+    defer v.mu.Unlock()
+    if v.closed {
+        return
+    }
+    height, width := v.height, v.width
+
+    var rows []string
+    for _, result := range v.rows {
+        ports := make([]string, 0, len(result.Ports))
+        for _, port := range result.Ports {
+            ports = append(ports, fmt.Sprintf("%d/%s", port.Port, port.Protocol))
+        }
+        line := fmt.Sprintf("%-39s %-24s %s", result.Host, result.Hostname, strings.Join(ports, " "))
+        if v.filter == "" || strings.Contains(line, v.filter) {
+            if len(line) > width {
+                line = line[:width]
+            }
+            rows = append(rows, line)
+        }
+    }
+    visible := height - 6
+    if visible < 1 {
+        visible = 1
+    }
+    if v.offset > len(rows)-visible {
+        v.offset = len(rows) - visible
+    }
+    if v.offset < 0 {
+        v.offset = 0
+    }
+
+    elapsed := time.Since(v.start)
+    if v.finished {
+        elapsed = v.elapsed
+    }
+    rate := float64(v.done) / elapsed.Seconds()
+    barWidth := width - 40
+    if barWidth < 10 {
+        barWidth = 10
+    }
+    filled := 0
+    if v.total > 0 {
+        filled = barWidth * v.done / v.total
+    }
+    var b strings.Builder
+    b.WriteString("\x1b[H\x1b[2J")
+    fmt.Fprintf(&b, "[%s%s] %d/%d hosts\r\n", strings.Repeat("#", filled), strings.Repeat("-", barWidth-filled), v.done, v.total)
+    fmt.Fprintf(&b, "%.1f hosts/s, %d open port(s) on %d host(s), %v elapsed\r\n", rate, v.openPorts, len(v.rows), elapsed.Round(time.Second))
+    switch {
+    case v.editing:
+        fmt.Fprintf(&b, "Filter: %s_\r\n", v.filter)
+    case v.filter != "":
+        fmt.Fprintf(&b, "Filter: %s (/ to change, Esc in filter to clear)\r\n", v.filter)
+    default:
+        b.WriteString("\r\n")
+    }
+    fmt.Fprintf(&b, "%-39s %-24s %s\r\n", "HOST", "NAME", "OPEN PORTS")
+    end := v.offset + visible
+    if end > len(rows) {
+        end = len(rows)
+    }
+    for _, row := range rows[v.offset:end] {
+        b.WriteString(row + "\r\n")
+    }
+    b.WriteString(fmt.Sprintf("\x1b[%d;1H", height))
+    switch {
+    case !v.keys:
+        b.WriteString("(keys unavailable: stdin is not a terminal)")
+    case v.finished:
+        b.WriteString("Scan complete. j/k scroll, / filter, q quit")
+    case v.pause.paused():
+        b.WriteString("PAUSED, r to resume. j/k scroll, space/b page, / filter")
+    default:
+        b.WriteString("j/k scroll, space/b page, / filter, p pause")
+    }
+    fmt.Print(b.String())
+}
+
+// cronSchedule is a standard five-field cron expression: minute, hour, day
+// of month, month and day of week (0 or 7 is Sunday).
+type cronSchedule struct {
+    minute, hour, day, month, weekday map[int]bool
+    // As in cron, when both day fields are restricted either may match.
+    anyDay, anyWeekday bool
+}
+
+var cronAliases = map[string]string{
+    "@hourly":  "0 * * * *",
+    "@daily":   "0 0 * * *",
+    "@midnight": "0 0 * * *",
+    "@weekly":  "0 0 * * 0",
+    "@monthly": "0 0 1 * *",
+    "@yearly":  "0 0 1 1 *",
+    "@annually": "0 0 1 1 *",
+}
+
+func parseCron(spec string) (*cronSchedule, error) {
+    if alias, ok := cronAliases[strings.TrimSpace(spec)]; ok {
+        spec = alias
+    }
+    fields := strings.Fields(spec)
+    if len(fields) != 5 {
+        return nil, fmt.Errorf("%q: expected 5 fields (minute hour day month weekday)", spec)
+    }
+    bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+    sets := make([]map[int]bool, 5)
+    for i, field := range fields {
+        set, err := parseCronField(field, bounds[i][0], bounds[i][1])
+        if err != nil {
+            return nil, fmt.Errorf("%q: %v", spec, err)
+        }
+        sets[i] = set
+    }
+    if sets[4][7] {
+        sets[4][0] = true
+    }
+    return &cronSchedule{
+        minute: sets[0], hour: sets[1], day: sets[2], month: sets[3], weekday: sets[4],
+        anyDay: fields[2] == "*", anyWeekday: fields[4] == "*",
+    }, nil
+}
+
+// parseCronField expands "*", "5", "1-5", "*/15", "10-40/10" and comma
+// separated lists of those.
+func parseCronField(field string, low, high int) (map[int]bool, error) {
+    set := map[int]bool{}
+    for _, part := range strings.Split(field, ",") {
+        step := 1
+        if i := strings.Index(part, "/"); i >= 0 {
+            var err error
+            step, err = strconv.Atoi(part[i+1:])
+            if err != nil || step < 1 {
+                return nil, fmt.Errorf("invalid step in %q", part)
+            }
+            part = part[:i]
+        }
+        start, end := low, high
+        if part != "*" {
+            bounds := strings.SplitN(part, "-", 2)
+            var err error
+            if start, err = strconv.Atoi(bounds[0]); err != nil {
+                return nil, fmt.Errorf("invalid value %q", part)
+            }
+            end = start
+            if len(bounds) == 2 {
+                if end, err = strconv.Atoi(bounds[1]); err != nil {
+                    return nil, fmt.Errorf("invalid value %q", part)
+                }
+            } else if step > 1 {
+                end = high
+            }
+        }
+        if start < low || end > high || start > end {
+            return nil, fmt.Errorf("%q out of range %d-%d", part, low, high)
+        }
+        for v := start; v <= end; v += step {
+            set[v] = true
+        }
+    }
+    return set, nil
+}
+
+// next returns the first matching minute after t, or the zero time if none
+// falls within the next four years (e.g. "0 0 31 2 *").
+func (c *cronSchedule) next(t time.Time) time.Time {
+    t = t.Truncate(time.Minute).Add(time.Minute)
+    for limit := t.AddDate(4, 0, 0); t.Before(limit); t = t.Add(time.Minute) {
+        if !c.month[int(t.Month())] {
+            t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location()).Add(-time.Minute)
+            continue
+        }
+        dayMatch := c.day[t.Day()]
+        weekdayMatch := c.weekday[int(t.Weekday())]
+        var matches bool
+        switch {
+        case c.anyDay && c.anyWeekday:
+            matches = true
+        case c.anyDay:
+            matches = weekdayMatch
+        case c.anyWeekday:
+            matches = dayMatch
+        default:
+            matches = dayMatch || weekdayMatch
+        }
+        if !matches {
+            t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location()).Add(-time.Minute)
+            continue
+        }
+        if c.hour[t.Hour()] && c.minute[t.Minute()] {
+            return t
+        }
+    }
+    return time.Time{}
+}
+
+// runScheduled repeats the scan on the cron schedule until interrupted. An
+// interrupt while waiting exits at once; during a scan it exits once that
+// scan has finished and been written out.
+func runScheduled(schedule *cronSchedule, targets []string, cfg Config) {
+    interrupts := make(chan os.Signal, 1)
+    signal.Notify(interrupts, os.Interrupt, syscall.SIGTERM)
+    // After the first run, notifications only report newly opened ports.
+    var previous []HostResult
+    for {
+        next := schedule.next(time.Now())
+        if next.IsZero() {
+            fmt.Println("[-] The schedule never matches, exiting.")
+            return
+        }
+        fmt.Printf("[*] Next scan at %s\n", next.Format(time.RFC3339))
+        timer := time.NewTimer(time.Until(next))
+        select {
+        case <-timer.C:
+        case <-interrupts:
+            timer.Stop()
+            fmt.Println("[*] Interrupted, exiting.")
+            return
+        }
+
+        output := ""
+        if outputFile != "" {
+            output = timestampedPath(outputFile, scanTag, next)
+        }
+        done := make(chan struct{})
+        go func() {
+            defer close(done)
+            previous, _ = runScan(context.Background(), targets, cfg, output, previous)
+        }()
+        select {
+        case <-done:
+        case <-interrupts:
+            fmt.Println("[*] Interrupted, exiting after the current scan (interrupt again to abort).")
+            go func() {
+                <-interrupts
+                restoreTerminal()
+                os.Exit(1)
+            }()
+            <-done
+            return
+        }
+    }
+}
+
+// timestampedPath turns "scan.json" into "scan-20060102-150405.json".
+// With a -tag it goes in too: "scan-prod-weekly-20060102-150405.json".
+func timestampedPath(path, tag string, t time.Time) string {
+    ext := filepath.Ext(path)
+    base := strings.TrimSuffix(path, ext)
+    if tag != "" {
+        base += "-" + filenameSafe(tag)
+    }
+    return base + "-" + t.Format("20060102-150405") + ext
+}
+
+// filenameSafe replaces everything but letters, digits, '.', '_' and '-'
+// with '-', so a tag cannot add directories or odd characters to a path.
+func filenameSafe(s string) string {
+    return strings.Map(func(r rune) rune {
+        if r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '.' || r == '_' || r == '-') {
+            return r
+        }
+        return '-'
+    }, s)
+}
+
+// appendFlag is a comma-separated list flag whose repeats add up, so
+// "-p 80 -p 443" is "-p 80,443".
+type appendFlag string
+
+func (f *appendFlag) String() string {
+    return string(*f)
+}
+
+func (f *appendFlag) Set(value string) error {
+    if *f != "" {
+        *f += ","
+    }
+    *f += appendFlag(value)
+    return nil
+}
+
+// stringListFlag collects every occurrence of a repeatable flag.
+type stringListFlag []string
+
+func (f *stringListFlag) String() string {
+    return strings.Join(*f, ",")
+}
+
+func (f *stringListFlag) Set(value string) error {
+    *f = append(*f, value)
+    return nil
+}
+
+// notifier posts scan summaries to a Slack or Discord incoming webhook.
+type notifier struct {
+    platform string
+    url      string
+}
+
+func parseNotifier(spec string) (notifier, error) {
+    platform, webhook, ok := strings.Cut(spec, ":")
+    platform = strings.ToLower(platform)
+    if !ok || (platform != "slack" && platform != "discord") {
+        return notifier{}, fmt.Errorf("%q: expected slack:URL or discord:URL", spec)
+    }
+    if u, err := url.Parse(webhook); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+        return notifier{}, fmt.Errorf("%q: invalid webhook URL", spec)
+    }
+    return notifier{platform: platform, url: webhook}, nil
+}
+
+// newlyOpenPorts returns the open ports in results that were not open in
+// previous, grouped by host.
+func newlyOpenPorts(previous, results []HostResult) []HostResult {
+    before := map[string]bool{}
+    for _, result := range previous {
+        for _, port := range result.Ports {
+            if port.State == "open" {
+                before[checkpointKey(result.Host, port.Port, port.Protocol)] = true
+            }
+        }
+    }
+    var changed []HostResult
+    for _, result := range results {
+        var ports []PortResult
+        for _, port := range result.Ports {
+            if port.State == "open" && !before[checkpointKey(result.Host, port.Port, port.Protocol)] {
+                ports = append(ports, port)
+            }
+        }
+        if len(ports) > 0 {
+            result.Ports = ports
+            changed = append(changed, result)
+        }
+    }
+    return changed
+}
+
+// notifyMessage formats hosts and their open ports as a short chat message,
+// listing at most 25 hosts.
+func notifyMessage(platform, title string, results []HostResult) string {
+    bold := "*"
+    if platform == "discord" {
+        bold = "**"
+    }
+    var b strings.Builder
+    fmt.Fprintf(&b, "%s%s%s\n", bold, title, bold)
+    if len(results) == 0 {
+        return b.String()
+    }
+    b.WriteString("```\n")
+    for i, result := range results {
+        if i == 25 {
+            fmt.Fprintf(&b, "... and %d more host(s)\n", len(results)-i)
+            break
+        }
+        ports := make([]string, 0, len(result.Ports))
+        for _, port := range result.Ports {
+            ports = append(ports, fmt.Sprintf("%d/%s", port.Port, port.Protocol))
+        }
+        fmt.Fprintf(&b, "%s: %s\n", result.Host, strings.Join(ports, ", "))
+    }
+    b.WriteString("```")
+    return b.String()
+}
+
+// send posts message, trying up to three times on network errors, 429 and
+// 5xx responses.
+func (n notifier) send(message string) error {
+    var payload map[string]string
+    if n.platform == "discord" {
+        // Discord rejects messages over 2000 characters. Cut on a
+        // character boundary: half a rune would be sent as U+FFFD.
+        if utf8.RuneCountInString(message) > 1990 {
+            message = string([]rune(message)[:1980]) + "\n...```"
+        }
+        payload = map[string]string{"content": message}
+    } else {
+        payload = map[string]string{"text": message}
+    }
+    body, err := json.Marshal(payload)
+    if err != nil {
+        return err
+    }
+    client := &http.Client{Timeout: 10 * time.Second}
+    for attempt := 1; ; attempt++ {
+        resp, err := client.Post(n.url, "application/json", strings.NewReader(string(body)))
+        if err == nil {
+            resp.Body.Close()
+            if resp.StatusCode/100 == 2 {
+                return nil
+            }
+            err = fmt.Errorf("%s webhook returned %s", n.platform, resp.Status)
+            if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+                return err
+            }
+        }
+        if attempt == 3 {
+            return err
+        }
+        time.Sleep(time.Duration(attempt) * time.Second)
+    }
+}
+
+// notifyAll sends the scan summary, or with previous results only the newly
+// opened ports, to every -notify target.
+func notifyAll(notifiers []notifier, targets []string, previous, results []HostResult) {
+    if len(notifiers) == 0 {
+        return
+    }
+    subject := strings.Join(targets, ",")
+    if scanTag != "" {
+        subject = fmt.Sprintf("%s (%s)", subject, scanTag)
+    }
+    title := fmt.Sprintf("Hunting-Rabbit scan of %s: open ports on %d host(s)", subject, len(results))
+    if previous != nil {
+        results = newlyOpenPorts(previous, results)
+        if len(results) == 0 {
+            return
+        }
+        title = fmt.Sprintf("Hunting-Rabbit scan of %s: new open ports on %d host(s)", subject, len(results))
+    }
+    for _, n := range notifiers {
+        if err := n.send(notifyMessage(n.platform, title, results)); err != nil {
+            fmt.Printf("[!] Notification failed: %v\n", err)
+        }
+    }
+}
+
+// Set at build time, e.g.
+//   go build -ldflags "-X main.version=1.2.0 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%FT%TZ)"
+// When unset they are filled from the module build info where possible.
+var (
+    version   = ""
+    commit    = ""
+    buildDate = ""
+)
+
+var goVersion = runtime.Version()
+
+var versionOnce sync.Once
+
+// loadVersion fills in whatever -ldflags left unset from the build info.
+func loadVersion() {
+    versionOnce.Do(func() {
+        if info, ok := debug.ReadBuildInfo(); ok {
+            if version == "" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+                version = info.Main.Version
+            }
+            for _, setting := range info.Settings {
+                switch {
+                case setting.Key == "vcs.revision" && commit == "":
+                    commit = setting.Value
+                case setting.Key == "vcs.time" && buildDate == "":
+                    buildDate = setting.Value
+                }
+            }
+            goVersion = info.GoVersion
+        }
+        for _, value := range []*string{&version, &commit, &buildDate} {
+            if *value == "" {
+                *value = "unknown"
+            }
+        }
+    })
+}
+
+// printPortList prints the ports a scan with portRange would probe on each
+// protocol, with their service names.
+func printPortList(portRange string, protocols []string, preserveOrder bool) error {
+    ports, err := parsePorts(portRange, preserveOrder)
+    if err != nil {
+        return err
+    }
+    spec := portRange
+    if spec == "" {
+        spec = "default"
+    }
+    plan := specPorts(portRange, ports, protocols)
+    for _, protocol := range protocols {
+        fmt.Printf("[*] %s: %d %s port(s)\n", spec, len(plan[protocol]), strings.ToUpper(protocol))
+        for _, port := range plan[protocol] {
+            line := fmt.Sprintf("    %5d/%s", port, protocol)
+            if name := serviceName(port, protocol); name != "" {
+                line += "  " + name
+            }
+            fmt.Println(line)
+        }
+    }
+    return nil
+}
+
+func printVersion() {
+    loadVersion()
+    fmt.Printf("Hunting-Rabbit-PortScanner %s\n", version)
+    fmt.Printf("  commit:     %s\n", commit)
+    fmt.Printf("  built:      %s\n", buildDate)
+    fmt.Printf("  go version: %s\n", goVersion)
+}
+
+// runSelfTest listens on a random loopback port and checks that a normal
+// connect scan of 127.0.0.1 reports it open.
+func runSelfTest(cfg Config) bool {
+    listener, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        fmt.Printf("[-] Self-test could not open a listener: %v\n", err)
+        return false
+    }
+    defer listener.Close()
+    go func() {
+        for {
+            conn, err := listener.Accept()
+            if err != nil {
+                return
+            }
+            conn.Close()
+        }
+    }()
+    port := listener.Addr().(*net.TCPAddr).Port
+    fmt.Printf("[*] Self-test: scanning listener on 127.0.0.1:%d...\n", port)
+    cfg.Targets, cfg.Ports = []string{"127.0.0.1"}, strconv.Itoa(port)
+    results, _, _ := ScanNetwork(context.Background(), cfg)
+    for _, result := range results {
+        for _, found := range result.Ports {
+            if found.Port == port && found.State == "open" {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+func main() {
+    // exitCode is set when the scan fails; it is applied once the deferred
+    // cleanups below have run.
+    exitCode := 0
+    defer func() {
+        if exitCode != 0 {
+            os.Exit(exitCode)
+        }
+    }()
+    flag.Parse()
+    explicit := map[string]bool{}
+    flag.Visit(func(f *flag.Flag) {
+        explicit[f.Name] = true
+    })
+    if explicit["t"] {
+        explicit["connect-timeout"] = true
+    }
+    var configTargets []string
+    if configFile != "" {
+        var err error
+        configTargets, err = loadConfigFile(configFile, explicit)
+        if err != nil {
+            fmt.Printf("Error: %v\n", err)
+            exitCode = 1
+            return
+        }
+    }
+    if err := applyEnvironment(explicit); err != nil {
+        fmt.Printf("Error: %v\n", err)
+        exitCode = 1
+        return
+    }
+
+    if showVersion {
+        printVersion()
+        return
+    }
+
+    if listPorts {
+        protocols, err := parseProtocols(protoList)
+        if err == nil {
+            err = printPortList(portRange, protocols, preserveOrder)
+        }
+        if err != nil {
+            fmt.Printf("Error: %v\n", err)
+            exitCode = 1
+        }
+        return
+    }
+
+    if selfTest {
+        cfg := Config{
+            Protocols:  []string{"tcp"},
+            Timeout:    time.Duration(timeout) * time.Millisecond,
+            MaxWorkers: 1,
+            ScanType:   "connect",
+            QuietSpecialUse: true,
+        }
+        if !runSelfTest(cfg) {
+            fmt.Println("[-] Self-test FAILED: the open loopback port was not detected.")
+            os.Exit(1)
+        }
+        fmt.Println("[+] Self-test passed.")
+        return
+    }
+
+    var endpoints []endpointResult
+    if endpointsFile != "" {
+        var lines []string
+        var err error
+        if endpointsFile == "-" {
+            lines, err = readTargets(os.Stdin)
+        } else {
+            lines, err = readTargetsFile(endpointsFile)
+        }
+        if err == nil {
+            endpoints, err = parseEndpoints(lines)
+        }
+        if err != nil {
+            fmt.Printf("Error: -endpoints: %v\n", err)
+            exitCode = 1
+            return
+        }
+        if len(endpoints) == 0 {
+            fmt.Println("Error: -endpoints: no endpoints listed")
+            exitCode = 1
+            return
+        }
+        if network != "" || inputList != "" || scheduleSpec != "" {
+            fmt.Println("Error: -endpoints cannot be combined with -n, -iL or -schedule")
+            exitCode = 1
+            return
+        }
+    }
+
+    targets := []string{}
+    if network != "" {
+        for _, target := range strings.Split(network, ",") {
+            if target = strings.TrimSpace(target); target != "" {
+                targets = append(targets, target)
+            }
+        }
+    }
+    var targetPorts map[string]string
+    if inputList != "" {
+        listTargets, err := readTargetsFile(inputList)
+        if err != nil {
+            fmt.Printf("Error: %v\n", err)
+            exitCode = 1
+            return
+        }
+        listTargets, targetPorts, err = splitTargetPorts(listTargets)
+        if err != nil {
+            fmt.Printf("Error: %s: %v\n", inputList, err)
+            exitCode = 1
+            return
+        }
+        targets = append(targets, listTargets...)
+    } else if endpoints != nil {
+        // The endpoints are the whole scan.
+    } else if network == "" && len(configTargets) > 0 {
+        targets = append(targets, configTargets...)
+    } else if network == "" && stdinIsPipe() {
+        stdinTargets, err := readTargets(os.Stdin)
+        if err != nil {
+            fmt.Printf("Error: %v\n", err)
+            exitCode = 1
+            return
+        }
+        targets = append(targets, stdinTargets...)
+    }
+    if len(targets) == 0 && endpoints == nil {
+        fmt.Println("Please specify a network to scan")
+        exitCode = 1
+        return
+    }
+    if err := validateTargets(targets); err != nil {
+        fmt.Printf("Error: %v\n", err)
+        exitCode = 1
+        return
+    }
+    if minPrefix < 0 || minPrefix > 32 {
+        fmt.Println("Error: -min-prefix must be between 0 and 32")
+        exitCode = 1
+        return
+    }
+    if broad := broadTargets(targets, minPrefix); len(broad) > 0 && !assumeYes {
+        fmt.Printf("Error: refusing to scan %s: prefixes shorter than /%d cover too many hosts and are usually a typo.\n", strings.Join(broad, ", "), minPrefix)
+        fmt.Println("       Pass -yes to scan them anyway, or lower -min-prefix.")
+        exitCode = 1
+        return
+    }
+    protocols, err := parseProtocols(protoList)
+    if err != nil {
+        fmt.Printf("Error: %v\n", err)
+        exitCode = 1
+        return
+    }
+    if portRange == "" && requirePorts {
+        fmt.Println("Error: -require-ports is set: give -p, or -p default for the built-in list")
+        exitCode = 1
+        return
+    }
+    if _, err := parsePorts(portRange, preserveOrder); err != nil {
+        fmt.Printf("Error: -p: %v\n", err)
+        exitCode = 1
+        return
+    }
+    if onlyPort != "" {
+        if _, err := parsePorts(onlyPort, false); err != nil {
+            fmt.Printf("Error: -only-port: %v\n", err)
+            exitCode = 1
+            return
+        }
+    }
+    for _, note := range groupProtocolNotes(portRange, protocols) {
+        fmt.Printf("[*] -p %s\n", note)
+    }
+    if maxWorkers < 1 {
+        fmt.Println("Error: -w must be at least 1")
+        exitCode = 1
+        return
+    }
+    if dnsTimeoutFlag <= 0 {
+        fmt.Println("Error: -dns-timeout must be positive")
+        exitCode = 1
+        return
+    }
+    if maxRetriesTotal < 0 {
+        fmt.Println("Error: -max-retries-total must not be negative")
+        exitCode = 1
+        return
+    }
+    if sequential && flat {
+        fmt.Println("Error: -sequential and -flat cannot be combined")
+        exitCode = 1
+        return
+    }
+    // Asking for a particular request implies making it.
+    if explicit["http-path"] || explicit["http-method"] {
+        httpProbe = true
+    }
+    if !strings.HasPrefix(httpPath, "/") {
+        fmt.Println("Error: -http-path must start with /")
+        exitCode = 1
+        return
+    }
+    if httpMethod == "" || strings.ContainsAny(httpMethod, " \t\r\n") {
+        fmt.Printf("Error: -http-method: invalid method %q\n", httpMethod)
+        exitCode = 1
+        return
+    }
+    if slowest < 0 {
+        fmt.Println("Error: -slowest must not be negative")
+        exitCode = 1
+        return
+    }
+    if maxGoroutines < 0 {
+        fmt.Println("Error: -max-goroutines must not be negative")
+        exitCode = 1
+        return
+    }
+    if flat && maxGoroutines > 0 && flatPoolSize(maxGoroutines) < 1 {
+        fmt.Printf("Error: -max-goroutines must be at least %d with -flat\n", goroutineReserve+3)
+        exitCode = 1
+        return
+    }
+    if !flat && maxGoroutines > 0 && probePoolSize(maxGoroutines, maxWorkers) < 1 {
+        fmt.Printf("Error: -max-goroutines must be at least %d with -w %d\n", 2*maxWorkers+goroutineReserve+1, maxWorkers)
+        exitCode = 1
+        return
+    }
+    if retries < 0 {
+        fmt.Println("Error: -retries must not be negative")
+        exitCode = 1
+        return
+    }
+    if maxConnsPerHost < 0 {
+        fmt.Println("Error: -max-conns-per-host must not be negative")
+        exitCode = 1
+        return
+    }
+    if timeout < 1 {
+        fmt.Println("Error: -connect-timeout must be at least 1 millisecond")
+        exitCode = 1
+        return
+    }
+    if bannerBytes < 1 {
+        fmt.Println("Error: -banner-bytes must be at least 1")
+        exitCode = 1
+        return
+    }
+    if readTimeout < 0 {
+        fmt.Println("Error: -read-timeout must not be negative")
+        exitCode = 1
+        return
+    }
+    if sample < 0 {
+        fmt.Println("Error: -sample must not be negative")
+        exitCode = 1
+        return
+    }
+    if jitter < 0 {
+        fmt.Println("Error: -jitter must not be negative")
+        exitCode = 1
+        return
+    }
+    if seed == 0 {
+        seed = time.Now().UnixNano()
+    }
+    scanType := "connect"
+    for name, set := range map[string]bool{"syn": synScan, "fin": finScan, "null": nullScan, "xmas": xmasScan, "ack": ackScan} {
+        if !set {
+            continue
+        }
+        if scanType != "connect" {
+            fmt.Println("Error: only one of -sS, -sF, -sN, -sX and -sA can be used")
+            exitCode = 1
+            return
+        }
+        scanType = name
+    }
+    var decoys []net.IP
+    if decoyList != "" {
+        if scanType != "syn" {
+            fmt.Println("Error: -decoys needs -sS")
+            exitCode = 1
+            return
+        }
+        if runtime.GOOS != "linux" {
+            fmt.Println("Error: -decoys is only supported on Linux")
+            exitCode = 1
+            return
+        }
+        decoys, err = parseDecoys(decoyList)
+        if err != nil {
+            fmt.Printf("Error: -decoys: %v\n", err)
+            exitCode = 1
+            return
+        }
+    }
+    var proxy *socksProxy
+    if envProxy {
+        proxy, err = proxyFromEnvironment()
+        if err != nil {
+            fmt.Printf("Error: %v\n", err)
+            exitCode = 1
+            return
+        }
+        if proxy == nil {
+            fmt.Println("Error: -env-proxy is set but ALL_PROXY is empty")
+            exitCode = 1
+            return
+        }
+    }
+    var sniNames []string
+    if sniList != "" {
+        sniNames, err = readTargetsFile(sniList)
+        if err != nil {
+            fmt.Printf("Error: %v\n", err)
+            exitCode = 1
+            return
+        }
+    }
+    var geoIP *mmdbReader
+    if geoIPPath != "" {
+        geoIP, err = openMMDB(geoIPPath)
+        if err != nil {
+            fmt.Printf("Error: %v\n", err)
+            exitCode = 1
+            return
+        }
+    }
+    var bandwidth *bandwidthLimiter
+    if maxBandwidth != "" {
+        bytesPerSecond, err := parseByteSize(maxBandwidth)
+        if err != nil {
+            fmt.Printf("Error: -max-bandwidth: %v\n", err)
+            exitCode = 1
+            return
+        }
+        bandwidth = newBandwidthLimiter(bytesPerSecond)
+    }
+    for _, spec := range notifySpecs {
+        n, err := parseNotifier(spec)
+        if err != nil {
+            fmt.Printf("Error: -notify: %v\n", err)
+            exitCode = 1
+            return
+        }
+        notifiers = append(notifiers, n)
+    }
+    var findings *findingsLog
+    if appendLog != "" {
+        findings, err = openFindingsLog(appendLog)
+        if err != nil {
+            fmt.Printf("Error: %v\n", err)
+            exitCode = 1
+            return
+        }
+        defer findings.Close()
+    }
+    var events *eventStream
+    if eventsFile != "" {
+        events, err = openEventStream(eventsFile)
+        if err != nil {
+            fmt.Printf("Error: %v\n", err)
+            exitCode = 1
+            return
+        }
+        defer events.Close()
+    }
+    var cp *checkpoint
+    if resumeFile != "" {
+        cp, err = openCheckpoint(resumeFile)
+        if err != nil {
+            fmt.Printf("Error: %v\n", err)
+            exitCode = 1
+            return
+        }
+        if len(cp.done) > 0 {
+            fmt.Printf("[*] Resuming: %d probe(s) already done in %s\n", len(cp.done), resumeFile)
+        }
+    }
+    var cache *resultCache
+    if cacheTTL > 0 {
+        if cacheFile == "" {
+            cacheFile = defaultCachePath()
+        }
+        cache, err = openResultCache(cacheFile, cacheTTL)
+        if err != nil {
+            fmt.Printf("Error: %v\n", err)
+            exitCode = 1
+            return
+        }
+        defer func() {
+            if err := cache.Close(); err != nil {
+                fmt.Printf("[!] Writing cache: %v\n", err)
+            }
+        }()
+        if n := cache.Len(); n > 0 {
+            fmt.Printf("[*] Cache: reusing results younger than %v, %d in %s\n", cacheTTL, n, cacheFile)
+        }
+    }
+    var resolver *net.Resolver
+    if resolverAddr != "" {
+        resolver = newResolver(resolverAddr)
+    }
+    cfg := Config{
+        Protocols:   protocols,
+        Timeout:     time.Duration(timeout) * time.Millisecond,
+        ReadTimeout: time.Duration(readTimeout) * time.Millisecond,
+        HostTimeout: hostTimeout,
+        MaxWorkers:  maxWorkers,
+        MaxConnsPerHost: maxConnsPerHost,
+        Retries:     retries,
+        Adaptive:    adaptive,
+        Reasons:     showReasons,
+        ShowSource:  showSource,
+        AbortOpen:   abortOpen,
+        MaxRetriesTotal: maxRetriesTotal,
+        Verbose:     verbose,
+        Sample:      sample,
+        Seed:        seed,
+        Banners:     banners,
+        BannerBytes: bannerBytes,
+        TLSInspect:  tlsInspect,
+        Favicon:     favicon,
+        HTTP:        httpProbe,
+        HTTPMethod:  strings.ToUpper(httpMethod),
+        HTTPPath:    httpPath,
+        TLSEnum:     tlsEnum,
+        SNINames:    sniNames,
+        Names:       lookupNames,
+        ARP:         arpLookup,
+        IdentifyProcess: identifyProcess,
+        GeoIP:       geoIP,
+        Proxy:       proxy,
+        Resolver:    resolver,
+        DNSTimeout:  dnsTimeoutFlag,
+        Bandwidth:   bandwidth,
+        Checkpoint:  cp,
+        Cache:       cache,
+        FindingsLog: findings,
+        Events:      events,
+        FromHost:    fromHost,
+        PreserveOrder: preserveOrder,
+        Flat:          flat,
+        Sequential:    sequential,
+        Slowest:       slowest,
+        MaxGoroutines: maxGoroutines,
+        TargetPorts: targetPorts,
+        QuietSpecialUse: quietSpecialUse,
+        AllowMulticast:  allowMulticast,
+        Jitter:      jitter,
+        ScanType:    scanType,
+        Decoys:      decoys,
+    }
+
+    // An interrupt ends the scan early; what it found is still reported.
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
+    if endpoints != nil {
+        if err := runEndpoints(ctx, endpoints, cfg, outputFile); err != nil {
+            exitCode = 1
+        }
+        return
+    }
+    if scheduleSpec != "" {
+        schedule, err := parseCron(scheduleSpec)
+        if err != nil {
+            fmt.Printf("Error: -schedule: %v\n", err)
+            exitCode = 1
+            return
+        }
+        if resumeFile != "" {
+            fmt.Println("Error: -resume cannot be combined with -schedule")
+            exitCode = 1
+            return
+        }
+        // Scheduled mode handles interrupts itself.
+        stop()
+        runScheduled(schedule, targets, cfg)
+        return
+    }
+    if _, err := runScan(ctx, targets, cfg, outputFile, nil); err != nil {
+        exitCode = 1
+    }
+}
+
+// runScan performs one scan, prints (and optionally writes) its report and
+// sends notifications. previous is the last run's results in scheduled mode,
+// so notifications can be limited to what changed; the results of this run
+// are returned for the next one. When the scan fails, previous is returned
+// with the error, so the next run still compares against a complete scan.
+// Cancelling ctx ends the scan and reports it as interrupted.
+func runScan(ctx context.Context, targets []string, cfg Config, outputFile string, previous []HostResult) ([]HostResult, error) {
+    start := time.Now()
+    loadVersion()
+    if scanTag != "" {
+        fmt.Printf("[*] Hunting-Rabbit-PortScanner %s started at %s, tag %q\n", version, start.Format(time.RFC3339), scanTag)
+    } else {
+        fmt.Printf("[*] Hunting-Rabbit-PortScanner %s started at %s\n", version, start.Format(time.RFC3339))
+    }
+    portSpec := portRange
+    if portSpec == "" {
+        portSpec = "default"
+    }
+    ports, _ := parsePorts(portRange, cfg.PreserveOrder)
+    portCount := fmt.Sprintf("%d port(s)", len(ports))
+    if portRange == "" {
+        // Each protocol has its own default list.
+        plan := specPorts(portRange, ports, cfg.Protocols)
+        counts := []string{}
+        for _, protocol := range cfg.Protocols {
+            counts = append(counts, fmt.Sprintf("%d %s", len(plan[protocol]), strings.ToUpper(protocol)))
+        }
+        portCount = strings.Join(counts, ", ") + " port(s)"
+    }
+    fmt.Printf("[*] Scanning network %s (%s: %s)...\n", strings.Join(targets, ","), portSpec, portCount)
+    cfg.pause = &pauseGate{}
+    var view *liveView
+    if liveTUI {
+        if isTerminal(os.Stdout) {
+            // Per-host lines would scribble over the table.
+            cfg.Verbose = false
+            view = newLiveView(ctx, cfg.pause)
+            cfg.progress = view.update
+        } else {
+            fmt.Println("[!] -tui needs a terminal on stdout, continuing without it")
+        }
+    }
+    var progress *progressReporter
+    if showProgress && view == nil {
+        progress = newProgressReporter(os.Stderr)
+        cfg.progress = progress.update
+    }
+    stopPauseKeys := func() {}
+    if view == nil {
+        stopPauseKeys = watchPauseKeys(cfg.pause)
+    }
+    cfg.Targets, cfg.Ports = targets, portRange
+    results, stats, err := ScanNetwork(ctx, cfg)
+    elapsed := time.Since(start)
+    if err != nil && ctx.Err() != nil {
+        err = errors.New("interrupted")
+    }
+    stopPauseKeys()
+    if cfg.Checkpoint != nil {
+        cfg.Checkpoint.Close()
+        // A failed scan keeps its checkpoint so it can be resumed.
+        if err == nil {
+            os.Remove(resumeFile)
+        }
+    }
+    if view != nil {
+        view.close()
+    }
+    if progress != nil {
+        progress.close()
+    }
+    if err != nil && len(results) == 0 {
+        fmt.Printf("Error: %v\n", err)
+        return previous, err
+    }
+
+    if excludeHoneypots {
+        kept := []HostResult{}
+        for _, result := range results {
+            if result.LikelyHoneypot {
+                fmt.Printf("[!] Excluding likely honeypot %s: %d/%d probed ports open\n", result.Host, result.Open, result.Probed)
+                continue
+            }
+            kept = append(kept, result)
+        }
+        results = kept
+    }
+    if onlyPort != "" {
+        wanted, _ := parsePorts(onlyPort, false)
+        results = Results(results).WithOpenPort(wanted)
+    }
+    if len(results) > 0 {
+        shown := results
+        if collapseIdentical {
+            if groups := uniformHosts(results); len(groups) > 0 {
+                shown = collapseUniform(results, groups)
+            }
+        }
+        if byPort {
+            fmt.Printf("[+] Found open ports on %d host(s), by port:\n", len(results))
+            printByPort(hostsByPort(shown))
+            // The by-port listing replaces the host by host one below.
+            shown = nil
+        } else {
+            fmt.Printf("[+] Found open ports on %d host(s):\n", len(results))
+        }
+        for _, result := range shown {
+            if result.Hostname != "" {
+                fmt.Printf("    %s (%s): %v (%d/%d ports open)\n", hostLabel(result.Host), result.Hostname, result.Ports, result.Open, result.Probed)
+            } else {
+                fmt.Printf("    %s: %v (%d/%d ports open)\n", hostLabel(result.Host), result.Ports, result.Open, result.Probed)
+            }
+            if result.LikelyHoneypot {
+                fmt.Printf("        Likely honeypot: %d/%d probed ports open\n", result.Open, result.Probed)
+            }
+            if result.Filtered > 0 {
+                fmt.Printf("        Not shown: %d filtered port(s)\n", result.Filtered)
+            }
+            if result.Geo != nil {
+                fmt.Printf("        Geo: %s\n", result.Geo)
+            }
+            if result.MAC != "" {
+                fmt.Printf("        MAC: %s %s\n", result.MAC, result.Vendor)
+            }
+            for _, port := range result.Ports {
+                if port.Process != "" {
+                    fmt.Printf("        %d/%s process: %s\n", port.Port, port.Protocol, port.Process)
+                }
+                if port.Reason != "" {
+                    fmt.Printf("        %d/%s reason: %s\n", port.Port, port.Protocol, port.Reason)
+                }
+                if port.Source != "" {
+                    fmt.Printf("        %d/%s source: %s\n", port.Port, port.Protocol, port.Source)
+                }
+                if port.Banner != "" {
+                    truncated := ""
+                    if port.BannerTruncated {
+                        truncated = " [truncated]"
+                    }
+                    banner := strings.TrimSpace(port.Banner)
+                    if !rawBanner {
+                        banner = sanitizeBanner(banner)
+                    }
+                    fmt.Printf("        %d/%s banner: %s%s\n", port.Port, port.Protocol, banner, truncated)
+                }
+                if port.TLS != nil {
+                    fmt.Printf("        %d/%s certificate: %s (issuer %s)\n", port.Port, port.Protocol, port.TLS.Subject, port.TLS.Issuer)
+                }
+                if port.FaviconHash != nil {
+                    fmt.Printf("        %d/%s favicon hash: %d\n", port.Port, port.Protocol, *port.FaviconHash)
+                }
+                if port.HTTP != nil {
+                    fmt.Printf("        %d/%s %s\n", port.Port, port.Protocol, port.HTTP)
+                }
+                for _, name := range sortedKeys(port.SNICerts) {
+                    fmt.Printf("        %d/%s SNI %s: %s\n", port.Port, port.Protocol, name, port.SNICerts[name].Subject)
+                }
+                if len(port.TLSVersions) > 0 {
+                    fmt.Printf("        %d/%s TLS: %s (%d cipher suite(s))\n", port.Port, port.Protocol, strings.Join(port.TLSVersions, ", "), len(port.TLSCiphers))
+                }
+            }
+        }
+        if freq := portFrequency(results); len(freq) > 0 {
+            printPortFrequency(freq, 10)
+        }
+        if clusterHosts {
+            if clusters := clusterFingerprints(results); len(clusters) > 0 {
+                printClusters(clusters)
+            }
+        }
+        if portMatrix {
+            printPortMatrix(results)
+        }
+        printWeakTLS(results)
+        printExpiringCerts(results, certExpiryDays, time.Now())
+    } else {
+        fmt.Println("[-] No open ports found on any host.")
+    }
+    if len(stats.Slowest) > 0 {
+        printSlowest(stats.Slowest)
+    }
+    // A report that could not be written fails the run, but the scan
+    // itself still counts as done.
+    var writeErr error
+    if outputFile != "" {
+        report := scanReport{
+            Metadata: scanMetadata{
+                Version: version,
+                Tag:     scanTag,
+                Args:    os.Args[1:],
+                Targets: targets,
+                Ports:   portSpec,
+                PortCount: len(ports),
+                Start:   start,
+                End:     start.Add(elapsed),
+            },
+            Hosts:         results,
+            PortFrequency: portFrequency(results),
+            Stats:         &stats,
+        }
+        if byPort {
+            report.ByPort = hostsByPort(results)
+        }
+        if clusterHosts {
+            report.Clusters = clusterFingerprints(results)
+        }
+        if hashResults {
+            report.Hash = resultsHash(results)
+        }
+        if writeErr = writeJSONReport(outputFile, report); writeErr != nil {
+            fmt.Printf("Error: %v\n", writeErr)
+        } else {
+            fmt.Printf("[+] Results written to %s\n", outputFile)
+        }
+    }
+    if hashResults {
+        fmt.Printf("[+] Results hash (SHA-256): %s\n", resultsHash(results))
+    }
+    notifyAll(notifiers, targets, previous, results)
+    if err != nil {
+        fmt.Printf("Error: %v\n", err)
+        fmt.Printf("[-] Scan failed after %v, the results above are partial.\n", elapsed)
+        return previous, err
+    }
+    fmt.Printf("[+] Scan completed in %v: %d host(s) scanned, %d up, %d open port(s), %d probes (%.0f/s).\n", elapsed, stats.HostsScanned, stats.HostsUp, stats.OpenPorts, stats.ProbesSent, stats.ProbesPerSec)
+    if len(stats.ProbeErrors) > 0 {
+        fmt.Printf("[!] Some probes failed on local or network errors, not target answers: %s\n", stats.probeErrorSummary())
+        if strict {
+            err := fmt.Errorf("-strict: probes failed on local or network errors")
+            fmt.Printf("Error: %v\n", err)
+            if results == nil {
+                results = []HostResult{}
+            }
+            return results, err
+        }
+    }
+    if results == nil {
+        results = []HostResult{}
+    }
+    return results, writeErr
+}
@@ -0,0 +1,563 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "crypto/tls"
+    "encoding/json"
+    "errors"
+    "io"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "reflect"
+    "runtime"
+    "strconv"
+    "strings"
+    "syscall"
+    "testing"
+    "time"
+    "unicode/utf8"
+)
+
+// TestScanNetworkNoHosts checks that a scan left with no hosts, because
+// none were given or all were dropped as multicast, returns at once with
+// nothing found and no error.
+func TestScanNetworkNoHosts(t *testing.T) {
+    for _, targets := range [][]string{nil, {"224.0.0.1"}, {"224.0.0.0/30", "239.255.255.250"}} {
+        done := make(chan struct{})
+        go func() {
+            defer close(done)
+            results, stats, err := ScanNetwork(context.Background(), Config{Targets: targets, Ports: "80"})
+            if err != nil || len(results) != 0 || stats.HostsScanned != 0 {
+                t.Errorf("ScanNetwork(%q) = %d results, %d hosts scanned, %v; want nothing and no error", targets, len(results), stats.HostsScanned, err)
+            }
+        }()
+        select {
+        case <-done:
+        case <-time.After(2 * time.Second):
+            t.Fatalf("ScanNetwork(%q) did not return", targets)
+        }
+    }
+}
+
+func TestParsePorts(t *testing.T) {
+    otPorts, err := parsePorts("ot", false)
+    if err != nil {
+        t.Fatal(err)
+    }
+    tests := []struct {
+        spec  string
+        want  []int
+        isErr bool
+    }{
+        {spec: "80,443", want: []int{80, 443}},
+        {spec: "80, 443, 8000 - 8002", want: []int{80, 443, 8000, 8001, 8002}},
+        {spec: " 22 ,22,  21-22 ", want: []int{21, 22}},
+        {spec: " ot ", want: otPorts},
+        {spec: " web ", isErr: true},
+        {spec: "80, ", isErr: true},
+        {spec: "8002 - 8000", isErr: true},
+        {spec: "0", isErr: true},
+        {spec: "65536", isErr: true},
+    }
+    for _, test := range tests {
+        got, err := parsePorts(test.spec, false)
+        if test.isErr {
+            if err == nil {
+                t.Errorf("parsePorts(%q) = %v, want an error", test.spec, got)
+            }
+            continue
+        }
+        if err != nil {
+            t.Errorf("parsePorts(%q): %v", test.spec, err)
+            continue
+        }
+        if !reflect.DeepEqual(got, test.want) {
+            t.Errorf("parsePorts(%q) = %v, want %v", test.spec, got, test.want)
+        }
+    }
+}
+
+// TestScanNetworkCancel cancels a scan far too large to finish and checks
+// that ScanNetwork returns promptly with ctx.Err() and leaves no goroutines
+// behind. MaxGoroutines keeps the probes on a pool, so the ports not yet
+// handed out when the scan is cancelled must be skipped, not queued.
+func TestScanNetworkCancel(t *testing.T) {
+    before := runtime.NumGoroutine()
+
+    ctx, cancel := context.WithCancel(context.Background())
+    cfg := Config{
+        Targets:    []string{"127.0.0.0/24"},
+        Ports:      "1-65535",
+        MaxWorkers: 4,
+        MaxGoroutines: 256,
+        Timeout:    time.Second,
+    }
+    done := make(chan error, 1)
+    go func() {
+        _, _, err := ScanNetwork(ctx, cfg)
+        done <- err
+    }()
+    time.Sleep(300 * time.Millisecond)
+    cancel()
+
+    select {
+    case err := <-done:
+        if !errors.Is(err, context.Canceled) {
+            t.Fatalf("ScanNetwork returned %v, want context.Canceled", err)
+        }
+    case <-time.After(2 * time.Second):
+        t.Fatal("ScanNetwork did not return within 2s of cancel")
+    }
+
+    deadline := time.Now().Add(5 * time.Second)
+    for runtime.NumGoroutine() > before {
+        if time.Now().After(deadline) {
+            buf := make([]byte, 1<<16)
+            t.Fatalf("%d goroutines before the scan, %d after:\n%s", before, runtime.NumGoroutine(), buf[:runtime.Stack(buf, true)])
+        }
+        time.Sleep(20 * time.Millisecond)
+    }
+}
+
+func TestHostsInNetwork(t *testing.T) {
+    tests := []struct {
+        network     string
+        fromHost    bool
+        count       int
+        first, last string
+    }{
+        {"192.168.1.0/24", false, 256, "192.168.1.0", "192.168.1.255"},
+        {"192.168.1.37/24", false, 256, "192.168.1.0", "192.168.1.255"},
+        {"192.168.1.37/24", true, 219, "192.168.1.37", "192.168.1.255"},
+        {"192.168.1.0/24", true, 256, "192.168.1.0", "192.168.1.255"},
+        {"10.0.0.4/31", false, 2, "10.0.0.4", "10.0.0.5"},
+        {"10.0.0.5/31", false, 2, "10.0.0.4", "10.0.0.5"},
+        {"10.0.0.5/31", true, 1, "10.0.0.5", "10.0.0.5"},
+        {"10.0.0.9/32", false, 1, "10.0.0.9", "10.0.0.9"},
+        {"10.0.0.9/32", true, 1, "10.0.0.9", "10.0.0.9"},
+        {"255.255.255.254/31", false, 2, "255.255.255.254", "255.255.255.255"},
+        {"10.0.0.9", false, 1, "10.0.0.9", "10.0.0.9"},
+    }
+    for _, test := range tests {
+        hosts, err := hostsInNetwork(test.network, test.fromHost)
+        if err != nil {
+            t.Errorf("hostsInNetwork(%q, %v): %v", test.network, test.fromHost, err)
+            continue
+        }
+        if len(hosts) != test.count || hosts[0] != test.first || hosts[len(hosts)-1] != test.last {
+            t.Errorf("hostsInNetwork(%q, %v) = %d hosts %s..%s, want %d hosts %s..%s", test.network, test.fromHost,
+                len(hosts), hosts[0], hosts[len(hosts)-1], test.count, test.first, test.last)
+        }
+    }
+}
+
+func TestHostBitsSet(t *testing.T) {
+    tests := []struct {
+        target  string
+        network string
+        set     bool
+    }{
+        {"192.168.1.37/24", "192.168.1.0/24", true},
+        {"192.168.1.0/24", "", false},
+        {"10.0.0.5/31", "10.0.0.4/31", true},
+        {"10.0.0.4/31", "", false},
+        {"10.0.0.9/32", "", false},
+        {"10.0.0.9", "", false},
+    }
+    for _, test := range tests {
+        network, set := hostBitsSet(test.target)
+        if network != test.network || set != test.set {
+            t.Errorf("hostBitsSet(%q) = %q, %v, want %q, %v", test.target, network, set, test.network, test.set)
+        }
+    }
+}
+
+func TestResultsHashIgnoresRunDetails(t *testing.T) {
+    a := []HostResult{
+        {Host: "10.0.0.1", Elapsed: time.Second, Ports: []PortResult{
+            {Port: 443, Protocol: "tcp", State: "open", Service: "https", Source: "10.0.0.9:40001", Reason: "syn-ack"},
+            {Port: 22, Protocol: "tcp", State: "open", Service: "ssh", Banner: "SSH-2.0-OpenSSH_9.6"},
+        }},
+        {Host: "10.0.0.2", Ports: []PortResult{{Port: 53, Protocol: "udp", State: "open|filtered"}}},
+    }
+    b := []HostResult{
+        {Host: "10.0.0.2", Ports: []PortResult{{Port: 53, Protocol: "udp", State: "open|filtered"}}},
+        {Host: "10.0.0.1", Elapsed: 2 * time.Second, Ports: []PortResult{
+            {Port: 22, Protocol: "tcp", State: "open", Service: "ssh", Banner: "SSH-2.0-OpenSSH_9.6"},
+            {Port: 443, Protocol: "tcp", State: "open", Service: "https", Source: "10.0.0.9:51234", Process: "nginx (pid 812)"},
+        }},
+    }
+    if resultsHash(a) != resultsHash(b) {
+        t.Error("resultsHash differs for the same findings in another order with other run details")
+    }
+    b[1].Ports[0].Banner = "SSH-2.0-OpenSSH_9.7"
+    if resultsHash(a) == resultsHash(b) {
+        t.Error("resultsHash does not change with a banner")
+    }
+}
+
+// TestCheckpointPortLineMarksDone resumes from a checkpoint cut off before
+// its done lines were flushed: the reported port must be restored once and
+// not probed again.
+func TestCheckpointPortLineMarksDone(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "scan.resume")
+    data := "done 10.0.0.1 tcp 1-21\n" +
+        `port 10.0.0.1 {"port":22,"protocol":"tcp","state":"open","service":"ssh"}` + "\n" +
+        `port 10.0.0.1 {"port":22,"protocol":"tcp","state":"open","service":"ssh","banner":"SSH-2.0"}` + "\n"
+    if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+        t.Fatal(err)
+    }
+    cp, err := openCheckpoint(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer cp.Close()
+    if !cp.completed("10.0.0.1", 22, "tcp") {
+        t.Error("port 22 has a port line but would be probed again")
+    }
+    if cp.completed("10.0.0.1", 23, "tcp") {
+        t.Error("port 23 is marked done without a record")
+    }
+    if restored := cp.restored["10.0.0.1"]; len(restored) != 1 || restored[0].Banner != "SSH-2.0" {
+        t.Errorf("restored %+v, want port 22 once, with the later banner", restored)
+    }
+}
+
+func TestResultCache(t *testing.T) {
+    c, err := openResultCache(filepath.Join(t.TempDir(), "cache.ndjson"), time.Hour)
+    if err != nil {
+        t.Fatal(err)
+    }
+    open := PortResult{Port: 22, Protocol: "tcp", State: "open"}
+    c.store("10.0.0.1", open, nil)
+    if _, ok := c.lookup("10.0.0.1", 22, "tcp", nil); !ok {
+        t.Error("plain lookup missed a plain result")
+    }
+    if _, ok := c.lookup("10.0.0.1", 22, "tcp", []string{"banner"}); ok {
+        t.Error("-banner lookup hit a result cached without a banner")
+    }
+    c.store("10.0.0.1", open, []string{"banner", "tls"})
+    if _, ok := c.lookup("10.0.0.1", 22, "tcp", []string{"banner"}); !ok {
+        t.Error("-banner lookup missed a result cached with a banner")
+    }
+
+    // Every port of 10.1.0.1, TCP then UDP, until the cache is full.
+    for i := 0; len(c.entries) < maxCacheEntries; i++ {
+        c.store("10.1.0.1", PortResult{Port: i%65535 + 1, Protocol: []string{"tcp", "udp"}[i/65535], State: "closed"}, nil)
+    }
+    c.store("10.2.0.1", PortResult{Port: 80, Protocol: "tcp", State: "closed"}, nil)
+    if _, ok := c.lookup("10.2.0.1", 80, "tcp", nil); ok {
+        t.Error("a closed result was added to a full cache")
+    }
+    c.store("10.2.0.1", PortResult{Port: 443, Protocol: "tcp", State: "open"}, nil)
+    if _, ok := c.lookup("10.2.0.1", 443, "tcp", nil); !ok {
+        t.Error("an open result was dropped from a full cache")
+    }
+}
+
+func TestResultsViews(t *testing.T) {
+    results := Results{
+        {Host: "10.0.0.1", State: "up", Ports: []PortResult{
+            {Port: 22, Protocol: "tcp", State: "open", Service: "ssh"},
+            {Port: 53, Protocol: "udp", State: "open|filtered"},
+        }},
+        {Host: "10.0.0.2", State: "up", Ports: []PortResult{{Port: 22, Protocol: "tcp", State: "open", Service: "ssh"}}},
+        {Host: "10.0.0.3", State: "down", Ports: []PortResult{{Port: 161, Protocol: "udp", State: "open|filtered"}}},
+    }
+    var open []string
+    for _, port := range results.OpenPorts() {
+        open = append(open, port.String())
+    }
+    if want := []string{"10.0.0.1 22/tcp open ssh", "10.0.0.2 22/tcp open ssh"}; !reflect.DeepEqual(open, want) {
+        t.Errorf("OpenPorts() = %q, want %q", open, want)
+    }
+    if got := results.ByState("open|filtered"); len(got) != 2 || got[0].Host != "10.0.0.1" || got[1].Host != "10.0.0.3" {
+        t.Errorf("ByState(open|filtered) = %v", got)
+    }
+    if got := results.HostsUp(); len(got) != 2 {
+        t.Errorf("HostsUp() = %d hosts, want 2", len(got))
+    }
+}
+
+// TestScanAbortNoteConnect checks that timeouts between routing failures
+// neither count as an answer nor keep the scan from being aborted, and that
+// a refused connection does.
+func TestScanAbortNoteConnect(t *testing.T) {
+    timeout := &net.OpError{Op: "dial", Net: "tcp", Err: os.ErrDeadlineExceeded}
+    unreachable := &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.EHOSTUNREACH)}
+
+    a := newScanAbort(context.Background())
+    for i := 0; i < noRouteLimit; i++ {
+        a.noteConnect(timeout)
+        a.noteConnect(unreachable)
+    }
+    if a.stopped() == "" {
+        t.Error("scan not aborted after only timeouts and unreachable hosts")
+    }
+
+    a = newScanAbort(context.Background())
+    a.noteConnect(syscall.ECONNREFUSED)
+    for i := 0; i < noRouteLimit; i++ {
+        a.noteConnect(unreachable)
+    }
+    if reason := a.stopped(); reason != "" {
+        t.Errorf("scan aborted after a refused connection: %s", reason)
+    }
+}
+
+// TestFetchHTTPFallsBackToHTTPS fetches from a TLS-only server, which like
+// nginx answers the plain HTTP attempt with a 400.
+func TestFetchHTTPFallsBackToHTTPS(t *testing.T) {
+    server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte("icon"))
+    }))
+    defer server.Close()
+    host, portStr, err := net.SplitHostPort(server.Listener.Addr().String())
+    if err != nil {
+        t.Fatal(err)
+    }
+    port, _ := strconv.Atoi(portStr)
+    client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}, Timeout: 5 * time.Second}
+    body, ok := fetchHTTP(context.Background(), client, host, port, "/favicon.ico")
+    if !ok || string(body) != "icon" {
+        t.Errorf("fetchHTTP = %q, %v, want the HTTPS body", body, ok)
+    }
+}
+
+// TestMMDBDecodeCorrupt decodes truncated and corrupt fields, which must
+// fail with an error rather than panic or recurse forever.
+func TestMMDBDecodeCorrupt(t *testing.T) {
+    tests := map[string][]byte{
+        "truncated pointer":       {0x28},
+        "pointer to itself":       {0x20, 0x00},
+        "truncated extended type": {0x00},
+        "truncated size":          {0x5e, 0x01},
+        "string past the end":     {0x45, 'a', 'b'},
+        "short double":            {0x62, 0x00, 0x00},
+        "map with a huge count":   {0xff, 0xff, 0xff, 0x00},
+        "array nested too deeply": append(bytes.Repeat([]byte{0x01, 0x04}, mmdbMaxDepth+2), 0x40),
+    }
+    for name, data := range tests {
+        r := &mmdbReader{data: data}
+        if value, _, err := r.decode(0, 0); err == nil {
+            t.Errorf("%s: decoded %v, want an error", name, value)
+        }
+    }
+}
+
+func TestInfrastructureError(t *testing.T) {
+    dial := func(errno syscall.Errno) error {
+        return &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", errno)}
+    }
+    tests := []struct {
+        err  error
+        want string
+    }{
+        {nil, ""},
+        {dial(syscall.ECONNREFUSED), ""},
+        {dial(syscall.ECONNABORTED), ""},
+        {dial(syscall.EHOSTUNREACH), ""},
+        {dial(syscall.EHOSTDOWN), ""},
+        {&net.OpError{Op: "dial", Net: "tcp", Err: os.ErrDeadlineExceeded}, ""},
+        {dial(syscall.EMFILE), syscall.EMFILE.Error()},
+        {dial(syscall.ENETUNREACH), syscall.ENETUNREACH.Error()},
+        {errors.New("unexpected"), "other error"},
+    }
+    for _, test := range tests {
+        if got := infrastructureError(test.err); got != test.want {
+            t.Errorf("infrastructureError(%v) = %q, want %q", test.err, got, test.want)
+        }
+    }
+}
+
+// TestDiscordTruncation sends a message of multi-byte characters over
+// Discord's limit: it must arrive cut on a character boundary, within
+// 2000 characters.
+func TestDiscordTruncation(t *testing.T) {
+    var content string
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        var payload map[string]string
+        if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+            t.Error(err)
+        }
+        content = payload["content"]
+        w.WriteHeader(http.StatusNoContent)
+    }))
+    defer server.Close()
+    if err := (notifier{platform: "discord", url: server.URL}).send(strings.Repeat("é", 3000)); err != nil {
+        t.Fatal(err)
+    }
+    if n := utf8.RuneCountInString(content); n > 2000 || strings.ContainsRune(content, utf8.RuneError) {
+        t.Errorf("Discord got %d characters, want at most 2000 and no U+FFFD", n)
+    }
+    if !strings.HasPrefix(content, strings.Repeat("é", 1980)) {
+        t.Error("Discord message was cut short of 1980 characters")
+    }
+}
+
+// TestHTMLTitle includes pages with invalid UTF-8 before the title, which
+// must not shift the title's offsets.
+func TestHTMLTitle(t *testing.T) {
+    tests := []struct {
+        page string
+        want string
+    }{
+        {"<html><head><TITLE>Router  Login</TITLE></head>", "Router Login"},
+        {"<title lang=en>a &amp; b</title>", "a & b"},
+        {"\xe9\xe9\xe9\xe9<title>x</title>", "x"},
+        {strings.Repeat("\xff", 12) + "<title>x</title>", "x"},
+        {"İİİİ<title>x</title>", "x"},
+        {"<title>unterminated", ""},
+        {"no title", ""},
+    }
+    for _, test := range tests {
+        if got := htmlTitle([]byte(test.page)); got != test.want {
+            t.Errorf("htmlTitle(%q) = %q, want %q", test.page, got, test.want)
+        }
+    }
+}
+
+// TestGrabHTTPFallsBackToHTTPS describes a TLS-only server by its HTTPS
+// answer, not by the 400 it sends the plain HTTP attempt.
+func TestGrabHTTPFallsBackToHTTPS(t *testing.T) {
+    server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte("<title>Dashboard</title>"))
+    }))
+    defer server.Close()
+    host, portStr, err := net.SplitHostPort(server.Listener.Addr().String())
+    if err != nil {
+        t.Fatal(err)
+    }
+    port, _ := strconv.Atoi(portStr)
+    client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}, Timeout: 5 * time.Second}
+    info := grabHTTP(context.Background(), client, host, port, Config{HTTPMethod: http.MethodGet, HTTPPath: "/"})
+    if info == nil || info.Scheme != "https" || info.Status != http.StatusOK || info.Title != "Dashboard" {
+        t.Errorf("grabHTTP = %+v, want the HTTPS 200", info)
+    }
+}
+
+func TestScanPort(t *testing.T) {
+    listener, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    open := listener.Addr().(*net.TCPAddr).Port
+    closed, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    closedPort := closed.Addr().(*net.TCPAddr).Port
+    closed.Close()
+    go func() {
+        for {
+            conn, err := listener.Accept()
+            if err != nil {
+                return
+            }
+            conn.Close()
+        }
+    }()
+    defer listener.Close()
+
+    ctx := context.Background()
+    if result, err := ScanPort(ctx, "127.0.0.1", open, time.Second); err != nil || result.State != "open" || result.Reason == "" {
+        t.Errorf("ScanPort(open) = %+v, %v", result, err)
+    }
+    if result, err := ScanPort(ctx, "127.0.0.1", closedPort, time.Second); err != nil || result.State != "closed" || result.Reason == "" {
+        t.Errorf("ScanPort(closed) = %+v, %v", result, err)
+    }
+    if _, err := ScanPort(ctx, "", open, time.Second); err == nil {
+        t.Error("ScanPort accepted an empty host")
+    }
+    if _, err := ScanPort(ctx, "127.0.0.1", 70000, time.Second); err == nil {
+        t.Error("ScanPort accepted port 70000")
+    }
+    cancelled, cancel := context.WithCancel(ctx)
+    cancel()
+    if _, err := ScanPort(cancelled, "127.0.0.1", closedPort, 0); !errors.Is(err, context.Canceled) {
+        t.Errorf("ScanPort with a cancelled ctx returned %v, want context.Canceled", err)
+    }
+}
+
+// TestScanNetworkPoolTooSmall checks that a MaxGoroutines leaving no room
+// for probe workers is an error instead of a scan that never ends.
+func TestScanNetworkPoolTooSmall(t *testing.T) {
+    for _, cfg := range []Config{
+        {Targets: []string{"127.0.0.1"}, Ports: "1", MaxWorkers: 4, MaxGoroutines: 10},
+        {Targets: []string{"127.0.0.1"}, Ports: "1", Flat: true, MaxGoroutines: 5},
+    } {
+        done := make(chan error, 1)
+        go func(cfg Config) {
+            _, _, err := ScanNetwork(context.Background(), cfg)
+            done <- err
+        }(cfg)
+        select {
+        case err := <-done:
+            if err == nil {
+                t.Errorf("ScanNetwork(MaxWorkers %d, MaxGoroutines %d, Flat %v) did not fail", cfg.MaxWorkers, cfg.MaxGoroutines, cfg.Flat)
+            }
+        case <-time.After(5 * time.Second):
+            t.Fatalf("ScanNetwork(MaxWorkers %d, MaxGoroutines %d, Flat %v) hung", cfg.MaxWorkers, cfg.MaxGoroutines, cfg.Flat)
+        }
+    }
+}
+
+func TestPrintExpiringCertsSNI(t *testing.T) {
+    now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+    valid := &TLSInfo{Subject: "CN=default", NotAfter: now.AddDate(1, 0, 0), Fingerprint: "aa"}
+    results := []HostResult{{Host: "10.0.0.1", Ports: []PortResult{{Port: 443, Protocol: "tcp", TLS: valid, SNICerts: map[string]*TLSInfo{
+        "default.example": valid,
+        "old.example":     {Subject: "CN=old.example", NotAfter: now.AddDate(0, 0, -3), Fingerprint: "bb"},
+        "soon.example":    {Subject: "CN=soon.example", NotAfter: now.AddDate(0, 0, 10), Fingerprint: "cc"},
+    }}}}}
+
+    r, w, err := os.Pipe()
+    if err != nil {
+        t.Fatal(err)
+    }
+    stdout := os.Stdout
+    os.Stdout = w
+    printExpiringCerts(results, 30, now)
+    os.Stdout = stdout
+    w.Close()
+    out, _ := io.ReadAll(r)
+
+    want := "[!] Certificates expiring within 30 day(s):\n" +
+        "    10.0.0.1:443/tcp SNI old.example: CN=old.example, EXPIRED on 2025-12-29\n" +
+        "    10.0.0.1:443/tcp SNI soon.example: CN=soon.example, expires in 10 day(s) on 2026-01-11\n"
+    if string(out) != want {
+        t.Errorf("printExpiringCerts printed:\n%s\nwant:\n%s", out, want)
+    }
+}
+
+func TestParseYAMLConfigComments(t *testing.T) {
+    data := "# scan settings\n" +
+        "note: \"rack #3\" # where it lives\n" +
+        "tag: 'lab #2'\n" +
+        "comment: it's fine # trailing\n" +
+        "p: [22, \"80 #x\"] # ports\n" +
+        "targets:\n" +
+        "  - 10.0.0.1 # gateway\n" +
+        "  - '10.0.0.#'\n"
+    values, err := parseYAMLConfig([]byte(data))
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := map[string][]string{
+        "note":    {"rack #3"},
+        "tag":     {"lab #2"},
+        "comment": {"it's fine"},
+        "p":       {"22", "80 #x"},
+        "targets": {"10.0.0.1", "10.0.0.#"},
+    }
+    if !reflect.DeepEqual(values, want) {
+        t.Errorf("parseYAMLConfig = %q, want %q", values, want)
+    }
+    if _, err := parseYAMLConfig([]byte("p: 22\nnote: \"rack #3\n")); err == nil || !strings.Contains(err.Error(), "line 2") {
+        t.Errorf("unterminated quote: got %v, want a line 2 error", err)
+    }
+}